@@ -0,0 +1,77 @@
+// Package config reads server settings from environment variables, falling
+// back to defaults when a variable is unset or malformed.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value of the environment variable or def if it is unset.
+func String(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Int returns the environment variable parsed as an int.
+func Int(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: \"%s\", using %d", name, value, def)
+		return def
+	}
+	return i
+}
+
+// Bool returns the environment variable parsed as a bool.
+func Bool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: \"%s\", using %t", name, value, def)
+		return def
+	}
+	return b
+}
+
+// Duration returns the environment variable parsed with time.ParseDuration.
+func Duration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: \"%s\", using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// List returns the environment variable split on commas, with blank entries
+// dropped.
+func List(name string, def []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns whether a statement updating the task's done column was sent.
+func sentDoneUpdate(conn *fakeConn, taskId string) bool {
+	for _, statement := range conn.sent() {
+		if strings.Contains(statement.query, `UPDATE "tasks" SET "done"`) && hasArg(statement.args, taskId) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRestoreActionRecomputesDone(t *testing.T) {
+	when := mustTime(t, "2017-01-10T12:00:00Z")
+	deletedAt := when.Add(time.Hour)
+	tests := []struct {
+		name       string
+		taskKind   TaskKind
+		actionKind ActionKind
+		// Whether the task is marked done
+		done bool
+	}{
+		{"completion of a task", TaskEnum, ActionDone, true},
+		{"progress on a task", TaskEnum, ActionProgress, false},
+		{"deferral of a task", TaskEnum, ActionDefer, false},
+		{"completion of a habit", HabitEnum, ActionDone, false},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, fixedClock(when),
+			fakeResult{`FROM "actions"`, []string{"id", "kind", "when", "task_id", "deleted_at"},
+				[][]driver.Value{{"a", int64(test.actionKind), when, "t", deletedAt}}},
+			fakeResult{`FROM "tasks"`, []string{"id", "kind"}, [][]driver.Value{{"t", int64(test.taskKind)}}},
+		)
+		action, err := db.RestoreAction("a", 1)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if action.DeletedAt != nil {
+			t.Errorf("%s: the action is still deleted", test.name)
+		}
+		if done := sentDoneUpdate(conn, "t"); done != test.done {
+			t.Errorf("%s: got the task marked done %t, want %t", test.name, done, test.done)
+		}
+	}
+}
+
+func TestUpdateActionWhen(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	earlier := mustTime(t, "2017-01-09T23:59:00Z")
+	future := now.Add(24 * time.Hour)
+	tests := []struct {
+		name       string
+		taskKind   TaskKind
+		actionKind ActionKind
+		attrs      map[string]interface{}
+		// Whether the action is moved and the task marked done
+		moved bool
+		done  bool
+		valid bool
+	}{
+		{"completion of a task", TaskEnum, ActionDone, map[string]interface{}{"when": &earlier}, true, true, true},
+		{"completion of a task into the future", TaskEnum, ActionDone, map[string]interface{}{"when": &future}, false, false, false},
+		{"note on a completion", TaskEnum, ActionDone, map[string]interface{}{"note": "late"}, false, false, true},
+		{"progress on a task", TaskEnum, ActionProgress, map[string]interface{}{"when": &earlier}, true, false, true},
+		{"completion of a habit", HabitEnum, ActionDone, map[string]interface{}{"when": &earlier}, true, false, true},
+		{"without a time", TaskEnum, ActionDone, map[string]interface{}{"when": nil}, false, false, false},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, fixedClock(now),
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(1)}}},
+			fakeResult{`FROM "actions"`, actionColumns, [][]driver.Value{{"a", int64(test.actionKind), now, "t"}}},
+			fakeResult{`FROM "tasks"`, []string{"id", "kind", "recurrence_rule"}, [][]driver.Value{{"t", int64(test.taskKind), ""}}},
+		)
+		action, err := db.UpdateAction("a", 1, test.attrs)
+		if !test.valid {
+			if _, ok := err.(*ValidationError); !ok {
+				t.Errorf("%s: got %v, want a ValidationError", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		moved := false
+		for _, statement := range conn.sent() {
+			if strings.Contains(statement.query, `UPDATE "actions"`) && hasTimeArg(statement.args, earlier) {
+				moved = true
+			}
+		}
+		if moved != test.moved {
+			t.Errorf("%s: got the action moved %t, want %t", test.name, moved, test.moved)
+		}
+		if moved && !action.When.Equal(earlier) {
+			t.Errorf("%s: got the action at %s, want %s", test.name, action.When, earlier)
+		}
+		if done := sentDoneUpdate(conn, "t"); done != test.done {
+			t.Errorf("%s: got the task marked done %t, want %t", test.name, done, test.done)
+		}
+	}
+}
+
+func TestStreakFollowsMovedCompletion(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	habit := &Task{Kind: HabitEnum, Interval: Daily, Frequency: 1}
+	for _, when := range mustTimes(t, "2017-01-08T08:00:00Z", "2017-01-10T08:00:00Z") {
+		when := when
+		habit.Actions = append(habit.Actions, Action{Kind: ActionDone, When: &when})
+	}
+	if streak := habitStreak(habit, now); streak != 1 {
+		t.Fatalf("got a streak of %d before the move, want 1", streak)
+	}
+
+	// Moving today's completion back to yesterday fills the gap, leaving
+	// today still to do
+	moved := mustTime(t, "2017-01-09T23:59:00Z")
+	habit.Actions[1].When = &moved
+	if streak := habitStreak(habit, now); streak != 2 {
+		t.Errorf("got a streak of %d after the move, want 2", streak)
+	}
+}
+
+func TestGetAction(t *testing.T) {
+	when := mustTime(t, "2017-01-10T12:00:00Z")
+	action := fakeResult{`FROM "actions"`, actionColumns, [][]driver.Value{{"a", int64(ActionDone), when, "t"}}}
+	tests := []struct {
+		name    string
+		results []fakeResult
+		found   bool
+	}{
+		{"owned", []fakeResult{action, {"count(*)", []string{"count"}, [][]driver.Value{{int64(1)}}}}, true},
+		{"not owned", []fakeResult{action, {"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}}}, false},
+		{"missing", nil, false},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, systemClock{}, test.results...)
+		got, err := db.GetAction("a", 1)
+		if !test.found {
+			if err != ErrNotFound {
+				t.Errorf("%s: got %v, %v, want ErrNotFound", test.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got.Id != "a" || got.TaskId != "t" || !got.When.Equal(when) {
+			t.Errorf("%s: got %+v", test.name, got)
+		}
+		// Ownership is checked against the user's tasks
+		checked := false
+		for _, statement := range conn.sent() {
+			if strings.Contains(statement.query, `FROM "tasks"`) && hasArg(statement.args, "t") && hasArg(statement.args, int64(1)) {
+				checked = true
+			}
+		}
+		if !checked {
+			t.Errorf("%s: the task's owner wasn't checked: %v", test.name, conn.sent())
+		}
+	}
+}
+
+func TestSetTasksDone(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	ids := []string{"a", "b", "c"}
+	for _, done := range []bool{true, false} {
+		var rows [][]driver.Value
+		for _, id := range ids {
+			rows = append(rows, []driver.Value{id, int64(TaskEnum), int64(1), !done})
+		}
+		db, conn := newFakeDatabase(t, fixedClock(now),
+			fakeResult{`INSERT INTO "actions"`, []string{"id"}, [][]driver.Value{{"action"}}},
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+			fakeResult{`FROM "tasks"`, []string{"id", "kind", "user_id", "done"}, rows},
+		)
+		count, err := db.SetTasksDone(ids, 1, done)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(ids) {
+			t.Errorf("done %t: got %d tasks updated, want %d", done, count, len(ids))
+		}
+
+		// The tasks are updated in one statement, and done tasks get a
+		// completion each
+		updates := 0
+		completed := make(map[string]bool)
+		for _, statement := range conn.sent() {
+			if strings.HasPrefix(statement.query, `UPDATE "tasks" SET`) && strings.Contains(statement.query, `"done" = `) {
+				updates++
+				for _, id := range ids {
+					if !hasArg(statement.args, id) {
+						t.Errorf("done %t: task %s wasn't updated by %s", done, id, statement.query)
+					}
+				}
+			}
+			if strings.HasPrefix(statement.query, `INSERT INTO "actions"`) && hasArg(statement.args, int64(ActionDone)) && hasTimeArg(statement.args, now) {
+				for _, id := range ids {
+					if hasArg(statement.args, id) {
+						completed[id] = true
+					}
+				}
+			}
+		}
+		if updates != 1 {
+			t.Errorf("done %t: got %d updates of done, want 1", done, updates)
+		}
+		for _, id := range ids {
+			if completed[id] != done {
+				t.Errorf("done %t: got completion of %s %t, want %t", done, id, completed[id], done)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// CustomActionKind is a user-defined kind of action for tracking events the
+// built-in kinds don't cover. Actions of a custom kind have the kind
+// ActionCustom and reference it by CustomKindId.
+type CustomActionKind struct {
+	Id        uint64     `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"-"`
+	UserId    uint64     `json:"user_id" gorm:"not_null;unique_index:idx_custom_action_kind_name"`
+	Name      string     `json:"name" gorm:"not_null;unique_index:idx_custom_action_kind_name"`
+}
+
+const maxActionKindNameLength = 50
+
+func (db gormDB) CreateActionKind(userId uint64, name string) (*CustomActionKind, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{"name", "can't be empty"}
+	}
+	if len(name) > maxActionKindNameLength {
+		return nil, &ValidationError{"name", "is too long"}
+	}
+
+	var count int
+	if err := db.Model(&CustomActionKind{}).Where("user_id = ? AND name = ?", userId, name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, &ValidationError{"name", "is already used by another action kind"}
+	}
+
+	kind := &CustomActionKind{
+		UserId: userId,
+		Name:   name,
+	}
+	if err := db.Create(kind).Error; err != nil {
+		return nil, err
+	}
+	return kind, nil
+}
+
+// Deletes a custom action kind. Existing actions of the kind are kept, but no
+// new ones can be added.
+func (db gormDB) DeleteActionKind(id uint64, userId uint64) error {
+	result := db.Model(&CustomActionKind{}).Where("id = ? AND user_id = ?", id, userId).UpdateColumn("deleted_at", db.Now())
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db gormDB) ListActionKinds(userId uint64) ([]CustomActionKind, error) {
+	var kinds []CustomActionKind
+	if err := db.Where("user_id = ?", userId).Order("name").Find(&kinds).Error; err != nil {
+		return nil, err
+	}
+	return kinds, nil
+}
+
+// Checks that an action's custom kind is set exactly when its kind is
+// ActionCustom and that the custom kind belongs to the user.
+func (db gormDB) validateCustomKind(action *Action, userId uint64) error {
+	if action.Kind != ActionCustom {
+		if action.CustomKindId != nil {
+			return &ValidationError{"customKindId", "can only be set on custom actions"}
+		}
+		return nil
+	}
+	if action.CustomKindId == nil {
+		return &ValidationError{"customKindId", "is required for custom actions"}
+	}
+	var count int
+	err := db.Model(&CustomActionKind{}).Where("id = ? AND user_id = ?", *action.CustomKindId, userId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return &ValidationError{"customKindId", "does not exist"}
+	}
+	return nil
+}
+
+// Returns the ID of an action's custom kind, or 0 if it has none, so custom
+// kinds can be part of map keys.
+func customKindIdOf(action *Action) uint64 {
+	if action.CustomKindId == nil {
+		return 0
+	}
+	return *action.CustomKindId
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ApiKey is a long-lived credential for integrations that can't use JWTs.
+// Only a hash of the key is stored, so the key itself is shown just once.
+type ApiKey struct {
+	Id         uint64     `json:"id" gorm:"primary_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DeletedAt  *time.Time `json:"-"`
+	UserId     uint64     `json:"user_id" gorm:"not_null;index"`
+	Label      string     `json:"label"`
+	HashedKey  string     `json:"-" gorm:"not_null;unique_index"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+const apiKeyPrefix = "ApiKey "
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates an API key for the user and returns the key along with its record.
+func (db gormDB) CreateApiKey(userId uint64, label string) (string, *ApiKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := "duet_" + hex.EncodeToString(secret)
+
+	apiKey := &ApiKey{
+		UserId:    userId,
+		Label:     label,
+		HashedKey: hashApiKey(key),
+	}
+	if err := db.Create(apiKey).Error; err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+func (db gormDB) RevokeApiKey(id uint64, userId uint64) error {
+	result := db.Model(&ApiKey{}).Where("id = ? AND user_id = ?", id, userId).UpdateColumn("deleted_at", db.Now())
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db gormDB) ListApiKeys(userId uint64) ([]ApiKey, error) {
+	var apiKeys []ApiKey
+	if err := db.Where("user_id = ?", userId).Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+// Returns the ID of the user owning an unrevoked key and records its use.
+func (db gormDB) AuthApiKey(key string) (uint64, error) {
+	var apiKey ApiKey
+	if err := db.Where("hashed_key = ?", hashApiKey(key)).First(&apiKey).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, fmt.Errorf("Invalid API key")
+		}
+		return 0, err
+	}
+	if err := db.Model(&apiKey).Update("last_used_at", db.Now()).Error; err != nil {
+		return 0, err
+	}
+	return apiKey.UserId, nil
+}
+
+// Authenticates a request with either a bearer token or an API key and
+// returns the user's ID. Deactivated users are rejected.
+func AuthRequest(db Database, r *http.Request) (uint64, error) {
+	userId, err := authCredentials(db, r)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkUserActive(db, userId); err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+func authCredentials(db Database, r *http.Request) (uint64, error) {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, apiKeyPrefix) {
+		return db.AuthApiKey(strings.TrimPrefix(authorization, apiKeyPrefix))
+	}
+
+	token, err := GetBearerToken(r)
+	if err != nil {
+		return 0, err
+	}
+	userId, err := AuthUserId(token)
+	if err != nil {
+		log.Printf("Error verifying token: %s", err.Error())
+		return 0, fmt.Errorf("Invalid token")
+	}
+	return userId, nil
+}
+
+var errUserDeactivated = fmt.Errorf("Account is deactivated")
+
+// Returns an error if the user has been deactivated or doesn't exist.
+func checkUserActive(db Database, userId uint64) error {
+	_, err := db.GetUserById(userId)
+	if err == gorm.ErrRecordNotFound {
+		return errUserDeactivated
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateApiKeyStoresHash(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`INSERT INTO "api_keys"`, []string{"id"}, [][]driver.Value{{int64(1)}}})
+	key, apiKey, err := db.CreateApiKey(1, "integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "duet_") || apiKey.HashedKey != hashApiKey(key) {
+		t.Errorf("got key %q hashed as %q", key, apiKey.HashedKey)
+	}
+	for _, statement := range conn.sent() {
+		if hasArg(statement.args, key) {
+			t.Errorf("the key itself was stored by %s", statement.query)
+		}
+	}
+}
+
+func TestApiKeyAuth(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	key := "duet_secret"
+	db, conn := newFakeDatabase(t, fixedClock(now),
+		fakeResult{`FROM "api_keys"`, []string{"id", "user_id", "hashed_key"}, [][]driver.Value{{int64(3), int64(1), hashApiKey(key)}}},
+		fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}},
+	)
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set("Authorization", "ApiKey "+key)
+	userId, err := AuthRequest(db, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId != 1 {
+		t.Errorf("got user %d, want 1", userId)
+	}
+
+	lookedUp, used := false, false
+	for _, statement := range conn.sent() {
+		if strings.Contains(statement.query, `FROM "api_keys"`) && hasArg(statement.args, hashApiKey(key)) {
+			lookedUp = true
+		}
+		if strings.HasPrefix(statement.query, `UPDATE "api_keys" SET "last_used_at"`) && hasTimeArg(statement.args, now) && hasArg(statement.args, int64(3)) {
+			used = true
+		}
+	}
+	if !lookedUp {
+		t.Errorf("the key wasn't looked up by its hash: %v", conn.sent())
+	}
+	if !used {
+		t.Errorf("the key's last use wasn't recorded: %v", conn.sent())
+	}
+}
+
+func TestRevokedApiKeyRejected(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	db, conn := newFakeDatabase(t, fixedClock(now))
+	if err := db.RevokeApiKey(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	statements := conn.sent()
+	if len(statements) != 1 || !hasTimeArg(statements[0].args, now) || !hasArg(statements[0].args, int64(3)) || !hasArg(statements[0].args, int64(1)) {
+		t.Errorf("the user's key wasn't soft deleted: %v", statements)
+	}
+
+	// Revoked keys are left out of lookups, so the fake finds no key
+	db, conn = newFakeDatabase(t, fixedClock(now))
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set("Authorization", "ApiKey duet_secret")
+	if _, err := AuthRequest(db, r); err == nil {
+		t.Error("a revoked key was accepted")
+	}
+	statements = conn.sent()
+	if len(statements) != 1 || !strings.Contains(statements[0].query, `"api_keys".deleted_at IS NULL`) {
+		t.Errorf("got %v, want only a lookup skipping revoked keys", statements)
+	}
+}
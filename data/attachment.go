@@ -0,0 +1,128 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andyzg/duet/config"
+	"github.com/jinzhu/gorm"
+)
+
+// Attachment links a task to a URL such as a document or web page. Only URL
+// references are supported, files aren't uploaded.
+type Attachment struct {
+	Id        uint64     `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"-"`
+	TaskId    string     `json:"task_id" gorm:"not_null;type:uuid;index"`
+	UserId    uint64     `json:"user_id" gorm:"not_null"`
+	Url       string     `json:"url" gorm:"not_null"`
+	Label     string     `json:"label"`
+}
+
+// The most attachments a task may have.
+var maxAttachmentsPerTask = config.Int("DUET_MAX_ATTACHMENTS_PER_TASK", 20)
+
+const maxAttachmentUrlLength = 2048
+
+func validateAttachmentUrl(rawUrl string) error {
+	if len(rawUrl) > maxAttachmentUrlLength {
+		return &ValidationError{"url", fmt.Sprintf("must be at most %d characters", maxAttachmentUrlLength)}
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return &ValidationError{"url", "must be an http or https URL"}
+	}
+	return nil
+}
+
+func (db gormDB) AddAttachment(taskId string, userId uint64, rawUrl string, label string) (*Attachment, error) {
+	if err := validateAttachmentUrl(rawUrl); err != nil {
+		return nil, err
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	attachment, err := tx.addAttachment(taskId, userId, rawUrl, label)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (tx gormDB) addAttachment(taskId string, userId uint64, rawUrl string, label string) (*Attachment, error) {
+	// Lock the task so concurrent adds can't exceed the attachment limit
+	var task Task
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id").
+		Where("id = ? AND user_id = ?", taskId, userId).
+		First(&task).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := tx.Model(&Attachment{}).Where("task_id = ?", taskId).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= maxAttachmentsPerTask {
+		return nil, &ValidationError{"task", fmt.Sprintf("can have at most %d attachments", maxAttachmentsPerTask)}
+	}
+
+	attachment := &Attachment{
+		TaskId: taskId,
+		UserId: userId,
+		Url:    rawUrl,
+		Label:  label,
+	}
+	if err := tx.Create(attachment).Error; err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Returns the attachments of one of the user's tasks, oldest first.
+func (db gormDB) ListAttachments(taskId string, userId uint64) ([]Attachment, error) {
+	exists, err := db.TaskExists(taskId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	var attachments []Attachment
+	if err := db.Where("task_id = ?", taskId).Order("created_at").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (db gormDB) DeleteAttachment(id uint64, userId uint64) error {
+	result := db.Model(&Attachment{}).Where("id = ? AND user_id = ?", id, userId).UpdateColumn("deleted_at", db.Now())
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Moves the attachments of a task being merged away onto the task it's merged
+// into, rejecting the move if the kept task would have too many.
+func (tx gormDB) moveAttachments(fromId string, toId string) error {
+	var count int
+	if err := tx.Model(&Attachment{}).Where("task_id IN (?)", []string{fromId, toId}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > maxAttachmentsPerTask {
+		return &ValidationError{"task", fmt.Sprintf("can have at most %d attachments", maxAttachmentsPerTask)}
+	}
+	return tx.Model(&Attachment{}).Where("task_id = ?", fromId).Update("task_id", toId).Error
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestValidateAttachmentUrl(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/doc", true},
+		{"http://example.com/doc?page=2", true},
+		{"", false},
+		{"example.com/doc", false},
+		{"ftp://example.com/doc", false},
+		{"javascript:alert(1)", false},
+		{"https://", false},
+		{"https://example.com/" + strings.Repeat("a", maxAttachmentUrlLength), false},
+	}
+	for _, test := range tests {
+		err := validateAttachmentUrl(test.url)
+		if test.valid && err != nil {
+			t.Errorf("%q: %s", test.url, err)
+		}
+		if _, ok := err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%q: got %v, want a ValidationError", test.url, err)
+		}
+	}
+}
+
+func TestAddAttachment(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		results []fakeResult
+		// Whether the attachment is added
+		added bool
+	}{
+		{"added", "https://example.com/doc", []fakeResult{
+			{`FROM "tasks"`, []string{"id"}, [][]driver.Value{{"t"}}},
+			{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+		}, true},
+		{"invalid URL", "not a url", nil, false},
+		{"not owned", "https://example.com/doc", nil, false},
+		{"too many", "https://example.com/doc", []fakeResult{
+			{`FROM "tasks"`, []string{"id"}, [][]driver.Value{{"t"}}},
+			{"count(*)", []string{"count"}, [][]driver.Value{{int64(maxAttachmentsPerTask)}}},
+		}, false},
+	}
+	for _, test := range tests {
+		results := append(test.results, fakeResult{`INSERT INTO "attachments"`, []string{"id"}, [][]driver.Value{{int64(1)}}})
+		db, conn := newFakeDatabase(t, systemClock{}, results...)
+		attachment, err := db.AddAttachment("t", 1, test.url, "Doc")
+		inserted := sentWithArgs(conn, `INSERT INTO "attachments"`, "t", int64(1), test.url, "Doc")
+		if inserted != test.added {
+			t.Errorf("%s: got inserted %t, want %t", test.name, inserted, test.added)
+		}
+		if !test.added {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, attachment)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
+
+func TestListAttachments(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(1)}}},
+		fakeResult{`FROM "attachments"`, []string{"id", "task_id", "url", "label"},
+			[][]driver.Value{{int64(1), "t", "https://example.com/a", "A"}, {int64(2), "t", "https://example.com/b", "B"}}},
+	)
+	attachments, err := db.ListAttachments("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || attachments[0].Url != "https://example.com/a" || attachments[1].Label != "B" {
+		t.Errorf("got %+v", attachments)
+	}
+	if !sentWithArgs(conn, `SELECT count(*) FROM "tasks"`, "t", int64(1)) {
+		t.Errorf("the task's owner wasn't checked: %v", conn.sent())
+	}
+
+	// Attachments of other users' tasks aren't listed
+	db, conn = newFakeDatabase(t, systemClock{},
+		fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}})
+	if _, err := db.ListAttachments("t", 2); err != ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if sentWithArgs(conn, `SELECT * FROM "attachments"`) {
+		t.Error("another user's attachments were loaded")
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	db, conn := newFakeDatabase(t, fixedClock(now))
+	if err := db.DeleteAttachment(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !sentWithArgs(conn, `UPDATE "attachments" SET "deleted_at"`, now, int64(3), int64(1)) {
+		t.Errorf("the user's attachment wasn't deleted: %v", conn.sent())
+	}
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"time"
+)
+
+// Returns whether a habit was completed on each day of a month, keyed by day
+// of the month. Days start at midnight in loc.
+func (db gormDB) GetHabitCalendar(taskId string, userId uint64, year int, month time.Month, loc *time.Location) (map[int]bool, error) {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 1, 0)
+
+	// The left join yields one row with a null time for a habit without
+	// completions that month, and no rows if the habit doesn't exist
+	rows, err := db.Table("tasks").
+		Select(`actions."when"`).
+		Joins(`LEFT JOIN actions ON actions.task_id = tasks.id AND actions.deleted_at IS NULL AND actions.kind = ? AND actions."when" >= ? AND actions."when" < ?`,
+			ActionDone, start, end).
+		Where("tasks.id = ? AND tasks.user_id = ? AND tasks.kind = ? AND tasks.deleted_at IS NULL", taskId, userId, HabitEnum).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendar := make(map[int]bool)
+	for day := 1; day <= end.AddDate(0, 0, -1).Day(); day++ {
+		calendar[day] = false
+	}
+	found := false
+	for rows.Next() {
+		found = true
+		var when *time.Time
+		if err := rows.Scan(&when); err != nil {
+			return nil, err
+		}
+		if when != nil {
+			calendar[when.In(loc).Day()] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return calendar, nil
+}
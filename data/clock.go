@@ -0,0 +1,26 @@
+package data
+
+import (
+	"time"
+)
+
+// Clock tells the data layer the current time so that streaks, due dates and
+// other time dependent results can be computed against a fixed time in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Now returns the current time according to the database's clock, which is
+// the system clock unless one was injected.
+func (db gormDB) Now() time.Time {
+	if db.clock == nil {
+		return time.Now()
+	}
+	return db.clock.Now()
+}
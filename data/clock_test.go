@@ -0,0 +1,248 @@
+package data
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func mustTime(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+var taskColumns = []string{"id", "kind", "title", "user_id", "done", "end_date", "interval", "frequency", "reminder_lead"}
+
+func habitRow(id string, interval Interval, reminderLead time.Duration) []driver.Value {
+	return []driver.Value{id, int64(HabitEnum), id, int64(1), false, nil, int64(interval), int64(1), int64(reminderLead)}
+}
+
+func taskRow(id string, endDate time.Time) []driver.Value {
+	return []driver.Value{id, int64(TaskEnum), id, int64(1), false, endDate, int64(Daily), int64(0), int64(0)}
+}
+
+var actionColumns = []string{"id", "kind", "when", "task_id"}
+
+// Returns DONE actions on a task at each of the times.
+func doneRows(taskId string, times ...time.Time) [][]driver.Value {
+	var rows [][]driver.Value
+	for i, when := range times {
+		rows = append(rows, []driver.Value{fmt.Sprintf("%s-%d", taskId, i), int64(ActionDone), when, taskId})
+	}
+	return rows
+}
+
+// Runs a query against the schema as user 1 and returns its data.
+func runQuery(t *testing.T, db Database, query string, variables map[string]interface{}) map[string]interface{} {
+	result := graphql.Do(graphql.Params{
+		Schema:         *GetSchema(db),
+		RequestString:  query,
+		VariableValues: variables,
+		Context:        context.WithValue(context.Background(), UserIdKey, uint64(1)),
+	})
+	if len(result.Errors) > 0 {
+		t.Fatalf("%s failed: %v", query, result.Errors)
+	}
+	return result.Data.(map[string]interface{})
+}
+
+func TestHabitStreakAtPeriodBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval Interval
+		done     []string
+		now      string
+		streak   int
+	}{
+		{"met at the start of today", Daily, []string{"2017-01-09T12:00:00Z", "2017-01-10T00:00:00Z"}, "2017-01-10T00:00:00Z", 2},
+		{"today just started", Daily, []string{"2017-01-09T12:00:00Z"}, "2017-01-10T00:00:00Z", 1},
+		{"last moment of today", Daily, []string{"2017-01-09T23:59:59Z"}, "2017-01-10T23:59:59Z", 1},
+		{"yesterday missed", Daily, []string{"2017-01-09T12:00:00Z"}, "2017-01-11T00:00:00Z", 0},
+		{"week just started", Weekly, []string{"2017-01-15T23:59:59Z"}, "2017-01-16T00:00:00Z", 1},
+		{"met on Monday only", Weekly, []string{"2017-01-16T00:00:00Z"}, "2017-01-16T00:00:00Z", 1},
+		{"month boundary", Monthly, []string{"2017-01-31T23:59:59Z", "2017-02-01T00:00:00Z"}, "2017-02-01T00:00:00Z", 2},
+		{"month missed", Monthly, []string{"2016-12-15T00:00:00Z", "2017-01-15T00:00:00Z"}, "2017-03-01T00:00:00Z", 0},
+	}
+	for _, test := range tests {
+		var done []time.Time
+		for _, value := range test.done {
+			done = append(done, mustTime(t, value))
+		}
+		db, _ := newFakeDatabase(t, fixedClock(mustTime(t, test.now)),
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{habitRow("h", test.interval, 0)}},
+			fakeResult{`FROM "actions"`, actionColumns, doneRows("h", done...)},
+		)
+		streak, err := db.GetHabitStreak("h", 1)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if streak != test.streak {
+			t.Errorf("%s: got a streak of %d, want %d", test.name, streak, test.streak)
+		}
+	}
+}
+
+func TestNextReminderAtPeriodBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		done     []string
+		now      string
+		reminder string
+	}{
+		{"just before the reminder", "UTC", nil, "2017-01-10T22:59:59Z", "2017-01-10T23:00:00Z"},
+		{"at the reminder", "UTC", nil, "2017-01-10T23:00:00Z", "2017-01-10T23:00:00Z"},
+		{"just after the reminder", "UTC", nil, "2017-01-10T23:00:01Z", "2017-01-11T23:00:00Z"},
+		{"met at the start of the day", "UTC", []string{"2017-01-10T00:00:00Z"}, "2017-01-10T12:00:00Z", "2017-01-11T23:00:00Z"},
+		{"met the day before", "UTC", []string{"2017-01-09T23:59:59Z"}, "2017-01-10T12:00:00Z", "2017-01-10T23:00:00Z"},
+		{"local day", "America/Toronto", nil, "2017-01-11T03:59:59Z", "2017-01-11T04:00:00Z"},
+		{"met at the start of the local day", "America/Toronto", []string{"2017-01-10T05:00:00Z"}, "2017-01-11T03:59:59Z", "2017-01-12T04:00:00Z"},
+	}
+	for _, test := range tests {
+		var done []time.Time
+		for _, value := range test.done {
+			done = append(done, mustTime(t, value))
+		}
+		db, _ := newFakeDatabase(t, fixedClock(mustTime(t, test.now)),
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{habitRow("h", Daily, time.Hour)}},
+			fakeResult{`FROM "actions"`, actionColumns, doneRows("h", done...)},
+		)
+		data := runQuery(t, db, `query($tz: String) { habit(id: "h") { nextReminder(timezone: $tz) } }`,
+			map[string]interface{}{"tz": test.timezone})
+		reminder := data["habit"].(map[string]interface{})["nextReminder"]
+		if reminder != test.reminder {
+			t.Errorf("%s: got a reminder at %v, want %s", test.name, reminder, test.reminder)
+		}
+	}
+}
+
+func TestTodayViewAtDayBoundaries(t *testing.T) {
+	tests := []struct {
+		timezone string
+		now      string
+		// The start of the local day containing now
+		dayStart string
+	}{
+		{"UTC", "2017-01-10T00:00:00Z", "2017-01-10T00:00:00Z"},
+		{"UTC", "2017-01-10T23:59:59Z", "2017-01-10T00:00:00Z"},
+		{"America/Toronto", "2017-01-10T05:00:00Z", "2017-01-10T05:00:00Z"},
+		{"America/Toronto", "2017-01-11T04:59:59Z", "2017-01-10T05:00:00Z"},
+	}
+	for _, test := range tests {
+		dayStart := mustTime(t, test.dayStart)
+		dayEnd := dayStart.AddDate(0, 0, 1).Add(-time.Second)
+		before := dayStart.Add(-time.Second)
+
+		db, _ := newFakeDatabase(t, fixedClock(mustTime(t, test.now)),
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{
+				taskRow("overdue", before),
+				taskRow("due-at-start", dayStart),
+				taskRow("due-at-end", dayEnd),
+				habitRow("met", Daily, 0),
+				habitRow("pending", Daily, 0),
+			}},
+			fakeResult{`FROM "actions"`, actionColumns, append(doneRows("met", dayStart), doneRows("pending", before)...)},
+		)
+		data := runQuery(t, db, `query($tz: String) {
+			today(timezone: $tz) { due_today { id } overdue { id } habits { id } }
+		}`, map[string]interface{}{"tz": test.timezone})
+		today := data["today"].(map[string]interface{})
+
+		want := map[string][]string{
+			"due_today": {"due-at-start", "due-at-end"},
+			"overdue":   {"overdue"},
+			"habits":    {"pending"},
+		}
+		for list, ids := range want {
+			got := today[list].([]interface{})
+			if len(got) != len(ids) {
+				t.Errorf("%s at %s: got %d %s, want %v", test.timezone, test.now, len(got), list, ids)
+				continue
+			}
+			for i, id := range ids {
+				if got[i].(map[string]interface{})["id"] != id {
+					t.Errorf("%s at %s: got %v in %s, want %v", test.timezone, test.now, got, list, ids)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestSoftDeletesUseClock(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	tests := []struct {
+		name string
+		run  func(db Database) error
+	}{
+		{"delete task", func(db Database) error {
+			_, err := db.DeleteTask("t", 1)
+			return err
+		}},
+		{"merge tasks", func(db Database) error {
+			_, err := db.MergeTasks("t", "u", 1)
+			return err
+		}},
+		{"delete action", func(db Database) error {
+			return db.DeleteAction("a", 1)
+		}},
+		{"deactivate user", func(db Database) error {
+			return db.DeactivateUser(2)
+		}},
+		{"merge users", func(db Database) error {
+			return db.MergeUsers(1, 2)
+		}},
+		{"revoke API key", func(db Database) error {
+			return db.RevokeApiKey(1, 1)
+		}},
+		{"delete action kind", func(db Database) error {
+			return db.DeleteActionKind(1, 1)
+		}},
+		{"delete attachment", func(db Database) error {
+			return db.DeleteAttachment(1, 1)
+		}},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, fixedClock(now),
+			// Neither merged task depends on the other
+			fakeResult{"FROM reachable", []string{"count"}, [][]driver.Value{{int64(0)}}},
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(1)}}},
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("t", now), taskRow("u", now)}},
+			fakeResult{`FROM "actions"`, actionColumns, doneRows("t", now)},
+			fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}, {int64(2)}}},
+		)
+		if err := test.run(db); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		deletes := 0
+		for _, statement := range conn.sent() {
+			// gorm's own soft deletes set deleted_at unquoted
+			if !strings.Contains(statement.query, `SET "deleted_at"`) && !strings.Contains(statement.query, "SET deleted_at") {
+				continue
+			}
+			deletes++
+			if !hasTimeArg(statement.args, now) {
+				t.Errorf("%s: deleted with %v, want the clock's time %s", test.name, statement.args, now)
+			}
+		}
+		if deletes == 0 {
+			t.Errorf("%s: nothing was deleted", test.name)
+		}
+	}
+}
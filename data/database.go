@@ -1,11 +1,17 @@
 package data
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/andyzg/duet/config"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -13,19 +19,109 @@ import (
 type Database interface {
 	Close() error
 	GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error)
-	GetTasks(userId uint64, kind *TaskKind) ([]Task, error)
-	AddTask(task *Task, userId uint64) error
+	GetTaskWithRecentActions(taskId string, userId uint64, kind *TaskKind, limit int) (*Task, error)
+	GetTasks(userId uint64, kind *TaskKind, opts TaskListOptions) ([]Task, error)
+	GetTasksByIds(taskIds []string, userId uint64) ([]Task, error)
+	TaskExists(taskId string, userId uint64) (bool, error)
+	GetTasksModifiedSince(userId uint64, since time.Time) ([]Task, error)
+	GetTasksIfChanged(userId uint64, known string) ([]Task, string, error)
+	AddTask(task *Task, userId uint64) (*Task, error)
+	AddTasks(tasks []*Task, userId uint64, skipInvalid bool) ([]*Task, []TaskError, error)
 	DeleteTask(taskId string, userId uint64) (bool, error)
+	RestoreTask(taskId string, userId uint64) (bool, error)
 	UpdateTask(taskId string, userId uint64, attrs map[string]interface{}) (*Task, error)
+	GetNextHabitDue(taskId string, userId uint64) (*time.Time, error)
+	GetHabitStreak(taskId string, userId uint64) (int, error)
+	GetLongestStreak(taskId string, userId uint64) (int, error)
+	RestartHabit(taskId string, userId uint64) (*Task, error)
+	GetHabitCalendar(taskId string, userId uint64, year int, month time.Month, loc *time.Location) (map[int]bool, error)
+	GetHabitTrend(taskId string, userId uint64, buckets int) ([]BucketStat, error)
+	GetHabitHourHistogram(taskId string, userId uint64, loc *time.Location) ([24]int, error)
+	PinTask(taskId string, userId uint64) (*Task, error)
+	UnpinTask(taskId string, userId uint64) (*Task, error)
+	SetTasksDone(taskIds []string, userId uint64, done bool) (int, error)
+	ArchiveCompletedTasks(userId uint64, olderThan time.Duration) (int, error)
+	TransferTask(taskId string, fromUserId uint64, toUserId uint64) (*Task, error)
+	MergeTasks(keepId string, mergeId string, userId uint64) (*Task, error)
 	CreateUser(username string, password string) (*User, error)
 	GetUserById(id uint64) (*User, error)
 	GetUserByUsername(username string) (*User, error)
+	DeactivateUser(id uint64) error
+	GetSignupStats(from time.Time, to time.Time) ([]DailyCount, error)
+	ReactivateUser(id uint64) error
+	MergeUsers(keepId uint64, mergeId uint64) error
+	GetAction(id string, userId uint64) (*Action, error)
 	AddAction(action *Action, userId uint64) error
+	UpdateAction(id string, userId uint64, attrs map[string]interface{}) (*Action, error)
+	DeduplicateActions(taskId string, userId uint64) (int, error)
+	ImportActions(userId uint64, actions []*Action) (int, int, error)
+	GetActionTimeline(userId uint64, from time.Time, to time.Time) ([]TimelineEntry, error)
+	GetTasksWithActivity(userId uint64, from time.Time, to time.Time, kind *ActionKind) ([]Task, error)
+	GetAllActions(userId uint64, filter ActionFilter) ([]TimelineAction, error)
+	SnapshotDay(day time.Time) error
+	GetSnapshots(userId uint64, from time.Time, to time.Time) ([]DailySnapshot, error)
+	CountCompletedTasks(userId uint64) (int64, error)
+	GetQuotaStatus(userId uint64) (int64, int64, error)
+	CountActionsSince(userId uint64, since time.Time) (int64, error)
+	GetLongestCurrentStreak(userId uint64) (int, error)
+	CountPendingTasks(userId uint64) (int64, error)
+	CountTasksByKind(userId uint64) (map[TaskKind]int64, error)
+	GetOverdueTasks(userId uint64, now time.Time) ([]Task, error)
+	GetRecentlyCompleted(userId uint64, limit int) ([]Task, error)
+	CountCompletionsSince(taskId string, userId uint64, since time.Time) (int64, error)
+	CountProgressSinceDone(taskId string, userId uint64) (int64, error)
+	GetTodayView(userId uint64, loc *time.Location, now time.Time) (TodayView, error)
 	DeleteAction(id string, userId uint64) error
+	RestoreAction(id string, userId uint64) (*Action, error)
+	CreateApiKey(userId uint64, label string) (string, *ApiKey, error)
+	RevokeApiKey(id uint64, userId uint64) error
+	ListApiKeys(userId uint64) ([]ApiKey, error)
+	AuthApiKey(key string) (uint64, error)
+	CreateActionKind(userId uint64, name string) (*CustomActionKind, error)
+	DeleteActionKind(id uint64, userId uint64) error
+	ListActionKinds(userId uint64) ([]CustomActionKind, error)
+	AddAttachment(taskId string, userId uint64, url string, label string) (*Attachment, error)
+	ListAttachments(taskId string, userId uint64) ([]Attachment, error)
+	DeleteAttachment(id uint64, userId uint64) error
+	AddDependency(taskId string, dependsOnTaskId string, userId uint64) (*Task, error)
+	RemoveDependency(taskId string, dependsOnTaskId string, userId uint64) (*Task, error)
+	IsTaskBlocked(taskId string, userId uint64) (bool, error)
+	GetDependencyIds(taskId string, userId uint64) ([]string, error)
+	GetPreferences(userId uint64) (string, error)
+	UpdatePreferences(userId uint64, preferences string) error
+	Now() time.Time
 }
 
+// ErrNotFound is returned when a record doesn't exist or isn't owned by the user.
+var ErrNotFound = errors.New("Record not found")
+
+// ErrDuplicateUsername is returned when creating a user with a username that
+// is already taken.
+var ErrDuplicateUsername = errors.New("Username is already taken")
+
+// The Postgres error code for a unique constraint violation.
+const uniqueViolation = "23505"
+
 type gormDB struct {
+	// The primary database, which all writes go to
 	*gorm.DB
+	clock Clock
+	// An optional read replica for reads that can tolerate replication lag
+	replica *gorm.DB
+}
+
+// Returns the handle to read from, which is the replica if there is one.
+// Transactions have no replica so their reads see their own writes.
+func (db gormDB) reader() gormDB {
+	if db.replica == nil {
+		return db
+	}
+	return gormDB{DB: db.replica, clock: db.clock}
+}
+
+// Returns the handle to the primary, for reads that must see recent writes.
+func (db gormDB) primary() gormDB {
+	return gormDB{DB: db.DB, clock: db.clock}
 }
 
 type TaskKind int
@@ -45,62 +141,226 @@ const (
 
 type Task struct {
 	// Common fields
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	DeletedAt *time.Time
-	Id        string   `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
-	Kind      TaskKind `json:"kind" gorm:"not_null"`
-	Title     string   `json:"title" gorm:"not_null"`
-	Done      bool     `json:"done" gorm:"not_null;default:false"`
-	UserId    uint64   `json:"user_id" gorm:"not_null"`
-	Actions   []Action `json:"actions" gorm:"ForeignKey:TaskId"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"-"`
+	Id        string     `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	Kind      TaskKind   `json:"kind" gorm:"not_null"`
+	Title     string     `json:"title" gorm:"not_null;size:255"`
+	Done      bool       `json:"done" gorm:"not_null;default:false"`
+	Pinned    bool       `json:"pinned" gorm:"not_null;default:false"`
+	Color     string     `json:"color"`
+	Icon      string     `json:"icon"`
+	UserId    uint64     `json:"user_id" gorm:"not_null"`
+	Actions   []Action   `json:"actions" gorm:"ForeignKey:TaskId"`
 	// Task Fields
-	StartDate *time.Time `json:"start_date"`
-	EndDate   *time.Time `json:"end_date"`
+	StartDate      *time.Time `json:"start_date"`
+	EndDate        *time.Time `json:"end_date"`
+	RecurrenceRule string     `json:"recurrence_rule"`
+	// Archived tasks are left out of task lists unless asked for
+	ArchivedAt *time.Time `json:"archived_at"`
 	// Habit Fields
 	Interval  Interval `json:"interval"`
 	Frequency int      `json:"frequency"`
+	// Streaks only count completions after the habit was last restarted
+	RestartedAt *time.Time `json:"restarted_at"`
+	// How long before the end of each period to remind the user if the habit
+	// hasn't been met yet, or 0 for no reminders
+	ReminderLead time.Duration `json:"reminder_lead"`
 }
 
 type ActionKind int
 
 const (
+	// Counts towards a task's progress until it's next marked done
 	ActionProgress ActionKind = iota
+	// Postpones a task without completing it
 	ActionDefer
+	// Completes a task, or one of a habit's completions for its period
 	ActionDone
+	// A user-defined kind, see CustomActionKind
+	ActionCustom
 )
 
 type Action struct {
-	Id     string     `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
-	Kind   ActionKind `json:"kind" gorm:"not_null"`
-	When   *time.Time `json:"when" gorm:"not_null"`
-	TaskId string     `json:"task_id" gorm:"not_null;type:uuid"`
+	Id        string     `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	Kind      ActionKind `json:"kind" gorm:"not_null"`
+	When      *time.Time `json:"when" gorm:"not_null;index:idx_actions_task_id_when"`
+	TaskId    string     `json:"task_id" gorm:"not_null;type:uuid;index:idx_actions_task_id_when"`
+	Note      string     `json:"note"`
+	DeletedAt *time.Time `json:"-"`
+	// Set when Kind is ActionCustom
+	CustomKindId *uint64 `json:"custom_kind_id"`
 }
 
 type User struct {
-	Id             uint64 `json:"id" gorm:"primary_key"`
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	DeletedAt      *time.Time
-	Username       string `json:"username" gorm:"not_null;unique"`
-	HashedPassword []byte `json:"-" gorm:"not_null"`
-	Tasks          []Task `json:"-" gorm:"ForeignKey:UserId"`
+	Id             uint64     `json:"id" gorm:"primary_key"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	DeletedAt      *time.Time `json:"-"`
+	Username       string     `json:"username" gorm:"not_null;unique;size:255"`
+	HashedPassword []byte     `json:"-" gorm:"not_null"`
+	Admin          bool       `json:"admin" gorm:"not_null;default:false"`
+	Tasks          []Task     `json:"-" gorm:"ForeignKey:UserId"`
 }
 
+// Models whose tables are managed by the server.
+var models = []interface{}{&Task{}, &User{}, &Action{}, &ApiKey{}, &UserPreferences{}, &CustomActionKind{}, &Attachment{}, &DailySnapshot{}, &TaskDependency{}}
+
+// Migrations should be run deliberately in production, so only migrate on
+// startup by default in development.
+var autoMigrate bool = config.Bool("DUET_AUTO_MIGRATE", config.String("DUET_ENV", "development") != "production")
+
+// How long Postgres lets a statement run before cancelling it, or 0 for no
+// limit. This also stops queries whose request was abandoned while holding a
+// connection.
+var statementTimeout = config.Duration("DUET_STATEMENT_TIMEOUT", 30*time.Second)
+
+// The host of a read replica of the database, if there is one.
+var replicaHost = config.String("DUET_REPLICA_HOST", "")
+
 func InitDatabase(dialect string, host string, user string, dbName string) Database {
-	db, err := gorm.Open(dialect, fmt.Sprintf("host=%s user=%s DB.name=%s sslmode=disable", host, user, dbName))
+	if err := checkLengthLimits(); err != nil {
+		panic(err)
+	}
+	db, err := gorm.Open(dialect, dataSourceName(host, user, dbName))
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&Task{}, &User{}, &Action{})
-	return gormDB{db}
+	if err := prepareSchema(db, autoMigrate); err != nil {
+		panic(err)
+	}
+	// Auto-migration only adds columns, so a renamed or removed field leaves
+	// its old column behind
+	for _, warning := range extraColumns(db) {
+		log.Printf("Warning: database schema has drifted from the models, %s", warning)
+	}
+
+	var replica *gorm.DB
+	if replicaHost != "" {
+		if replica, err = gorm.Open(dialect, dataSourceName(replicaHost, user, dbName)); err != nil {
+			panic(err)
+		}
+	}
+	return NewDatabase(db, replica, systemClock{})
+}
+
+// NewDatabase wraps open connections to the primary and, if it isn't nil, a
+// read replica in a Database. The current time is read from clock, so
+// streaks and due dates can be pinned to a fixed time.
+func NewDatabase(db *gorm.DB, replica *gorm.DB, clock Clock) Database {
+	return gormDB{DB: db, clock: clock, replica: replica}
+}
+
+func dataSourceName(host string, user string, dbName string) string {
+	dsn := fmt.Sprintf("host=%s user=%s DB.name=%s sslmode=disable", host, user, dbName)
+	if statementTimeout > 0 {
+		// Unknown connection parameters are set on each new connection
+		dsn += fmt.Sprintf(" statement_timeout=%d", statementTimeout/time.Millisecond)
+	}
+	return dsn
+}
+
+// Migrates the database schema if auto is set, and otherwise checks that the
+// schema has every table and column of the models.
+func prepareSchema(db *gorm.DB, auto bool) error {
+	if auto {
+		return db.AutoMigrate(models...).Error
+	}
+	if missing := missingColumns(db); len(missing) > 0 {
+		return fmt.Errorf("Database schema is out of date and DUET_AUTO_MIGRATE is disabled, missing: %s",
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Returns the tables and columns of the models that don't exist in the database.
+func missingColumns(db *gorm.DB) []string {
+	var missing []string
+	for _, model := range models {
+		scope := db.NewScope(model)
+		tableName := scope.TableName()
+		if !db.HasTable(model) {
+			missing = append(missing, tableName)
+			continue
+		}
+		for _, field := range scope.GetStructFields() {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			if !db.Dialect().HasColumn(tableName, field.DBName) {
+				missing = append(missing, tableName+"."+field.DBName)
+			}
+		}
+	}
+	return missing
+}
+
+// Describes the columns in the models' tables that no model field maps to.
+func extraColumns(db *gorm.DB) []string {
+	var extra []string
+	for _, model := range models {
+		scope := db.NewScope(model)
+		tableName := scope.TableName()
+		known := make(map[string]bool)
+		for _, field := range scope.GetStructFields() {
+			if field.IsNormal && !field.IsIgnored {
+				known[field.DBName] = true
+			}
+		}
+
+		rows, err := db.Raw(`SELECT column_name, is_nullable = 'NO' AND column_default IS NULL
+			FROM information_schema.columns
+			WHERE table_schema = CURRENT_SCHEMA() AND table_name = ?`, tableName).Rows()
+		if err != nil {
+			log.Printf("Checking columns of %s failed, %s", tableName, err.Error())
+			continue
+		}
+		for rows.Next() {
+			var column string
+			var required bool
+			if err := rows.Scan(&column, &required); err != nil {
+				log.Printf("Checking columns of %s failed, %s", tableName, err.Error())
+				break
+			}
+			if known[column] {
+				continue
+			}
+			if required {
+				extra = append(extra, fmt.Sprintf("%s.%s is not used by any model but is NOT NULL without a default, so inserts will fail", tableName, column))
+			} else {
+				extra = append(extra, fmt.Sprintf("%s.%s is not used by any model", tableName, column))
+			}
+		}
+		rows.Close()
+	}
+	return extra
 }
 
 func (db gormDB) Close() error {
-	return db.Close()
+	if db.replica != nil {
+		if err := db.replica.Close(); err != nil {
+			return err
+		}
+	}
+	return db.DB.Close()
 }
 
 func (db gormDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error) {
+	// TODO: Only preload actions if necessary
+	return db.getTask(taskId, userId, kind, 0)
+}
+
+// Like GetTask but only loads the task's limit most recent actions, newest
+// first. Streaks, due dates and progress are computed from all of a task's
+// actions, so they can't be worked out from the task this returns.
+func (db gormDB) GetTaskWithRecentActions(taskId string, userId uint64, kind *TaskKind, limit int) (*Task, error) {
+	return db.getTask(taskId, userId, kind, limit)
+}
+
+// Loads a task with its actions, or only the actionsLimit most recent ones if
+// it's positive.
+func (db gormDB) getTask(taskId string, userId uint64, kind *TaskKind, actionsLimit int) (*Task, error) {
 	whereFields := map[string]interface{}{
 		"id":      taskId,
 		"user_id": userId,
@@ -108,47 +368,357 @@ func (db gormDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, e
 	if kind != nil {
 		whereFields["kind"] = *kind
 	}
+	actions := func(query *gorm.DB) *gorm.DB {
+		return query
+	}
+	if actionsLimit > 0 {
+		actions = func(query *gorm.DB) *gorm.DB {
+			return query.Order(`"when" DESC`).Limit(actionsLimit)
+		}
+	}
 
 	var task Task
-	// TODO: Only preload actions if necessary
-	if err := db.Preload("Actions").Where(whereFields).First(&task).Error; err != nil {
+	if err := db.reader().Preload("Actions", actions).Where(whereFields).First(&task).Error; err != nil {
 		return nil, err
 	}
 	return &task, nil
 }
 
-func (db gormDB) GetTasks(userId uint64, kind *TaskKind) ([]Task, error) {
+// TaskListOptions controls which tasks GetTasks returns and their order.
+type TaskListOptions struct {
+	PinnedOnly      bool
+	PinnedFirst     bool
+	IncludeArchived bool
+}
+
+func (db gormDB) GetTasks(userId uint64, kind *TaskKind, opts TaskListOptions) ([]Task, error) {
 	whereFields := map[string]interface{}{
 		"user_id": userId,
 	}
 	if kind != nil {
 		whereFields["kind"] = *kind
 	}
+	if opts.PinnedOnly {
+		whereFields["pinned"] = true
+	}
+
+	query := db.reader().Preload("Actions").Where(whereFields)
+	if !opts.IncludeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+	if opts.PinnedFirst {
+		query = query.Order("pinned DESC").Order("created_at")
+	}
 
 	var tasks []Task
 	// TODO: Only preload actions if necessary
-	if err := db.Preload("Actions").Where(whereFields).Find(&tasks).Error; err != nil {
+	if err := query.Find(&tasks).Error; err != nil {
 		return nil, err
 	}
 	return tasks, nil
 }
 
-func (db gormDB) AddTask(task *Task, userId uint64) error {
+// Returns the user's tasks that were created, updated or deleted after since,
+// including deleted tasks so that clients can sync deletions. This reads from
+// the primary: clients move since forward after each sync, so a change a
+// lagging replica didn't have yet would never be synced.
+func (db gormDB) GetTasksModifiedSince(userId uint64, since time.Time) ([]Task, error) {
+	var tasks []Task
+	err := db.Unscoped().
+		Preload("Actions").
+		Where("user_id = ? AND (updated_at > ? OR deleted_at > ?)", userId, since, since).
+		Order("updated_at").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Returns a version of the user's tasks that changes whenever one of them or
+// their actions is created, updated or deleted, for use as an ETag.
+func (db gormDB) GetTasksVersion(userId uint64) (string, error) {
+	var version struct {
+		LastModified *time.Time
+		Count        int64
+	}
+	// Deleted tasks are included for their deletion time, and the count
+	// catches tasks transferred to another user
+	err := db.Table("tasks").
+		Select("max(greatest(updated_at, deleted_at)) AS last_modified, sum(CASE WHEN deleted_at IS NULL THEN 1 ELSE 0 END) AS count").
+		Where("user_id = ?", userId).
+		Scan(&version).Error
+	if err != nil {
+		return "", err
+	}
+	if version.LastModified == nil {
+		return "0-0", nil
+	}
+	return fmt.Sprintf("%d-%d", version.LastModified.UnixNano(), version.Count), nil
+}
+
+// Returns the user's tasks and their version, both read from one snapshot of
+// the same database so a version never describes a staler or newer list. If
+// the version equals known the tasks aren't loaded and nil is returned.
+func (db gormDB) GetTasksIfChanged(userId uint64, known string) ([]Task, string, error) {
+	tx := gormDB{DB: db.reader().Begin(), clock: db.clock}
+	tasks, version, err := tx.getTasksIfChanged(userId, known)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+	return tasks, version, tx.Commit().Error
+}
+
+func (tx gormDB) getTasksIfChanged(userId uint64, known string) ([]Task, string, error) {
+	if err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY").Error; err != nil {
+		return nil, "", err
+	}
+	version, err := tx.GetTasksVersion(userId)
+	if err != nil {
+		return nil, "", err
+	}
+	if version == known {
+		return nil, version, nil
+	}
+	tasks, err := tx.GetTasks(userId, nil, TaskListOptions{})
+	if tasks == nil {
+		tasks = []Task{}
+	}
+	return tasks, version, err
+}
+
+// Returns the user's tasks with the given IDs. IDs of tasks that don't exist
+// or belong to someone else are skipped. This reads from the primary since
+// clients fetch IDs they've just learned of, such as from a create, and a
+// lagging replica would silently skip them.
+func (db gormDB) GetTasksByIds(taskIds []string, userId uint64) ([]Task, error) {
+	tasks := []Task{}
+	if len(taskIds) == 0 {
+		return tasks, nil
+	}
+	if err := db.Preload("Actions").Where("id IN (?) AND user_id = ?", taskIds, userId).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Returns whether the user has a task with the given ID without loading it.
+func (db gormDB) TaskExists(taskId string, userId uint64) (bool, error) {
+	var count int
+	err := db.Model(&Task{}).Where("id = ? AND user_id = ?", taskId, userId).Limit(1).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Creates a task and returns it as stored, including the values the database
+// fills in. Clients may choose the task's ID so that a create can be safely
+// retried: if the user already has a task of the same kind with the ID it's
+// returned instead.
+func (db gormDB) AddTask(task *Task, userId uint64) (*Task, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	created, err := tx.addTask(task, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	// Read from the primary since a replica may not have the task yet
+	return db.primary().GetTask(created, userId, nil)
+}
+
+// Creates the task unless the user already has one with its ID, returning
+// the ID of the task.
+func (tx gormDB) addTask(task *Task, userId uint64) (string, error) {
+	if task.Id != "" {
+		var existing Task
+		err := tx.Select("id").Where("id = ? AND user_id = ? AND kind = ?", task.Id, userId, task.Kind).First(&existing).Error
+		if err == nil {
+			return existing.Id, nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return "", err
+		}
+	}
+	if err := tx.checkTaskLimit(userId, 1); err != nil {
+		return "", err
+	}
+	if err := tx.createTask(task, userId); err != nil {
+		return "", err
+	}
+	return task.Id, nil
+}
+
+// Validates and creates a task without checking the task limit, which doesn't
+// apply to the next occurrences of recurring tasks.
+func (db gormDB) createTask(task *Task, userId uint64) error {
+	task.Title = normalizeTitle(task.Title)
+	if err := validateTask(task); err != nil {
+		return err
+	}
 	task.UserId = userId
 	return db.Create(task).Error
 }
 
-// Deletes the task with the given ID and returns whether a row was deleted.
+// Returns a TaskLimitError if adding tasks would take the user over the task
+// limit. The user is locked so concurrent adds can't each fit under it.
+func (tx gormDB) checkTaskLimit(userId uint64, adding int) error {
+	if maxTasksPerUser <= 0 {
+		return nil
+	}
+	var user User
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").Where("id = ?", userId).First(&user).Error; err != nil {
+		return err
+	}
+	var count int
+	if err := tx.Model(&Task{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count+adding > maxTasksPerUser {
+		return &TaskLimitError{maxTasksPerUser}
+	}
+	return nil
+}
+
+// TaskError is why one task in a batch was rejected.
+type TaskError struct {
+	// The position of the task in the batch
+	Index int
+	*ValidationError
+}
+
+// ValidateTasks runs the checks AddTasks makes on a batch of tasks without
+// creating them, returning the errors for all invalid ones. Titles are
+// normalized in place.
+func ValidateTasks(tasks []*Task) ([]TaskError, error) {
+	invalid := []TaskError{}
+	for i, task := range tasks {
+		task.Title = normalizeTitle(task.Title)
+		err := validateTask(task)
+		if validationErr, ok := err.(*ValidationError); ok {
+			invalid = append(invalid, TaskError{i, validationErr})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return invalid, nil
+}
+
+// Validates every task in a batch before creating any of them, returning the
+// created tasks and the errors for all invalid ones. If skipInvalid is set
+// the valid tasks are still created, otherwise nothing is created when any
+// task is invalid.
+func (db gormDB) AddTasks(tasks []*Task, userId uint64, skipInvalid bool) ([]*Task, []TaskError, error) {
+	invalid, err := ValidateTasks(tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+	rejected := make(map[int]bool)
+	for _, taskErr := range invalid {
+		rejected[taskErr.Index] = true
+	}
+	valid := make([]*Task, 0, len(tasks))
+	for i, task := range tasks {
+		if rejected[i] {
+			continue
+		}
+		task.UserId = userId
+		valid = append(valid, task)
+	}
+	if len(invalid) > 0 && !skipInvalid {
+		return []*Task{}, invalid, nil
+	}
+
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	if err := tx.checkTaskLimit(userId, len(valid)); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	for _, task := range valid {
+		if err := tx.Create(task).Error; err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, err
+	}
+	return valid, invalid, nil
+}
+
+// Soft deletes a task along with its actions so they no longer appear in
+// timelines or stats. Returns whether the task existed.
 func (db gormDB) DeleteTask(taskId string, userId uint64) (bool, error) {
-	task := Task{
-		Id:     taskId,
-		UserId: userId,
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	deleted, err := tx.deleteTask(taskId, userId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
 	}
-	result := db.Where(&task).Delete(&task)
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+func (tx gormDB) deleteTask(taskId string, userId uint64) (bool, error) {
+	// The task and its actions share a deletion time so that RestoreTask can
+	// tell them apart from actions that were deleted earlier
+	now := tx.Now()
+	result := tx.Model(&Task{}).Where("id = ? AND user_id = ?", taskId, userId).UpdateColumn("deleted_at", now)
 	if err := result.Error; err != nil {
 		return false, err
 	}
-	return result.RowsAffected > 0, nil
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	if err := tx.Model(&Action{}).Where("task_id = ?", taskId).UpdateColumn("deleted_at", now).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Restores a deleted task along with the actions that were deleted with it.
+func (db gormDB) RestoreTask(taskId string, userId uint64) (bool, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	restored, err := tx.restoreTask(taskId, userId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return restored, nil
+}
+
+func (tx gormDB) restoreTask(taskId string, userId uint64) (bool, error) {
+	var task Task
+	err := tx.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", taskId, userId).First(&task).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = tx.Unscoped().Model(&Action{}).
+		Where("task_id = ? AND deleted_at = ?", taskId, task.DeletedAt).
+		UpdateColumn("deleted_at", nil).Error
+	if err != nil {
+		return false, err
+	}
+	// Bump updated_at so syncing clients see the task again
+	restored := map[string]interface{}{"deleted_at": nil, "updated_at": tx.Now()}
+	if err := tx.Unscoped().Model(&task).UpdateColumns(restored).Error; err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Updates a task with the given attributes and returns the updated Task if one exists for the ID.
@@ -156,6 +726,28 @@ func (db gormDB) UpdateTask(taskId string, userId uint64, attrs map[string]inter
 	task := Task{
 		Id: taskId,
 	}
+	if err := filterTaskAttrs(attrs); err != nil {
+		return nil, err
+	}
+	if title, ok := attrs["title"].(string); ok {
+		attrs["title"] = normalizeTitle(title)
+	}
+	if err := validateTaskAttrs(attrs); err != nil {
+		return nil, err
+	}
+	if err := db.validateHabitUpdate(taskId, userId, attrs); err != nil {
+		return nil, err
+	}
+
+	// Completing a recurring task creates its next occurrence
+	completing := false
+	if done, _ := attrs["done"].(bool); done {
+		var current Task
+		if err := db.Where("id = ? AND user_id = ?", taskId, userId).First(&current).Error; err == nil {
+			completing = !current.Done && current.Kind == TaskEnum
+		}
+	}
+
 	result := db.Model(&task).Where("user_id = ?", userId).Updates(attrs)
 	if err := result.Error; err != nil {
 		return nil, err
@@ -163,6 +755,11 @@ func (db gormDB) UpdateTask(taskId string, userId uint64, attrs map[string]inter
 	if result.RowsAffected == 0 {
 		return nil, fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
 	}
+	if completing {
+		if err := db.addNextOccurrence(taskId, userId); err != nil {
+			return nil, err
+		}
+	}
 	// TODO: Only query actions if necessary
 	if err := db.Model(&task).Related(&task.Actions).Error; err != nil {
 		return nil, err
@@ -170,7 +767,317 @@ func (db gormDB) UpdateTask(taskId string, userId uint64, attrs map[string]inter
 	return &task, nil
 }
 
+// Sets the done state of the user's tasks with the given IDs and returns how
+// many changed. Marking tasks done also records a done action for each.
+// Habits and tasks already in that state are skipped, since marking a habit
+// done would retire it.
+func (db gormDB) SetTasksDone(taskIds []string, userId uint64, done bool) (int, error) {
+	if len(taskIds) == 0 {
+		return 0, nil
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	count, err := tx.setTasksDone(taskIds, userId, done)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (tx gormDB) setTasksDone(taskIds []string, userId uint64, done bool) (int, error) {
+	var tasks []Task
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id IN (?) AND user_id = ? AND kind = ? AND done <> ?", taskIds, userId, TaskEnum, done).
+		Find(&tasks).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+
+	changedIds := make([]string, len(tasks))
+	for i, task := range tasks {
+		changedIds[i] = task.Id
+	}
+	if err := tx.Model(&Task{}).Where("id IN (?)", changedIds).Update("done", done).Error; err != nil {
+		return 0, err
+	}
+	if !done {
+		return len(tasks), nil
+	}
+
+	now := tx.Now()
+	for i := range tasks {
+		task := &tasks[i]
+		action := &Action{
+			Kind:   ActionDone,
+			When:   &now,
+			TaskId: task.Id,
+		}
+		if err := tx.addAction(action, userId); err != nil {
+			return 0, err
+		}
+		if task.RecurrenceRule != "" {
+			next, err := nextOccurrence(task, now)
+			if err != nil {
+				return 0, err
+			}
+			if err := tx.createTask(next, userId); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(tasks), nil
+}
+
+// Returns when the habit is next expected to be completed, or nil if it's retired.
+func (db gormDB) GetNextHabitDue(taskId string, userId uint64) (*time.Time, error) {
+	kind := HabitEnum
+	habit, err := db.GetTask(taskId, userId, &kind)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return habitNextDue(habit), nil
+}
+
+// Returns the habit's current streak, counting from its last restart.
+func (db gormDB) GetHabitStreak(taskId string, userId uint64) (int, error) {
+	kind := HabitEnum
+	habit, err := db.GetTask(taskId, userId, &kind)
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return habitStreak(habit, db.Now()), nil
+}
+
+func (db gormDB) GetLongestStreak(taskId string, userId uint64) (int, error) {
+	kind := HabitEnum
+	habit, err := db.GetTask(taskId, userId, &kind)
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return habitLongestStreak(habit), nil
+}
+
+// Restarts a habit's streak from now and returns the habit. Earlier actions
+// are kept for stats and the timeline but no longer count towards the streak.
+func (db gormDB) RestartHabit(taskId string, userId uint64) (*Task, error) {
+	result := db.Model(&Task{}).
+		Where("id = ? AND user_id = ? AND kind = ?", taskId, userId, HabitEnum).
+		Update("restarted_at", db.Now())
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	// Read from the primary since a replica may not have the restart yet
+	kind := HabitEnum
+	return db.primary().GetTask(taskId, userId, &kind)
+}
+
+// Archives the user's done tasks that were completed more than olderThan ago,
+// returning how many were archived. A task was completed at its latest done
+// action, or at its last update if it was marked done without one.
+func (db gormDB) ArchiveCompletedTasks(userId uint64, olderThan time.Duration) (int, error) {
+	now := db.Now()
+	result := db.Model(&Task{}).
+		Where("user_id = ? AND kind = ? AND done = ? AND archived_at IS NULL", userId, TaskEnum, true).
+		Where(`COALESCE((
+			SELECT max(actions."when") FROM actions
+			WHERE actions.task_id = tasks.id AND actions.deleted_at IS NULL AND actions.kind = ?
+		), tasks.updated_at) < ?`, ActionDone, now.Add(-olderThan)).
+		UpdateColumns(map[string]interface{}{
+			"archived_at": now,
+			"updated_at":  now,
+		})
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected), nil
+}
+
+func (db gormDB) PinTask(taskId string, userId uint64) (*Task, error) {
+	return db.UpdateTask(taskId, userId, map[string]interface{}{"pinned": true})
+}
+
+func (db gormDB) UnpinTask(taskId string, userId uint64) (*Task, error) {
+	return db.UpdateTask(taskId, userId, map[string]interface{}{"pinned": false})
+}
+
+// Creates the next occurrence of a task if it has a recurrence rule.
+func (db gormDB) addNextOccurrence(taskId string, userId uint64) error {
+	var task Task
+	if err := db.Where("id = ? AND user_id = ?", taskId, userId).First(&task).Error; err != nil {
+		return err
+	}
+	if task.RecurrenceRule == "" {
+		return nil
+	}
+	next, err := nextOccurrence(&task, db.Now())
+	if err != nil {
+		return err
+	}
+	return db.createTask(next, userId)
+}
+
+// Reassigns a task and its actions from one user to another. The recipient
+// must have room for the task under the task limit.
+func (db gormDB) TransferTask(taskId string, fromUserId uint64, toUserId uint64) (*Task, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	task, err := tx.transferTask(taskId, fromUserId, toUserId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (tx gormDB) transferTask(taskId string, fromUserId uint64, toUserId uint64) (*Task, error) {
+	task, err := tx.GetTask(taskId, fromUserId, nil)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.GetUserById(toUserId); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("User %d does not exist", toUserId)
+		}
+		return nil, err
+	}
+	if err := tx.checkTaskLimit(toUserId, 1); err != nil {
+		return nil, err
+	}
+	// Actions belong to the task, so they follow it to the new owner
+	if err := tx.Model(task).Update("user_id", toUserId).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&Attachment{}).Where("task_id = ?", task.Id).Update("user_id", toUserId).Error; err != nil {
+		return nil, err
+	}
+	// The task's dependencies are all on the sender's tasks, so they're dropped
+	// rather than left linking the two users' tasks
+	err = tx.Where("task_id = ? OR depends_on_task_id = ?", task.Id, task.Id).Delete(&TaskDependency{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Moves the actions, attachments and dependencies of the task with mergeId to
+// the task with keepId and deletes the merged task. Both tasks must be the
+// same kind. Returns the kept task with all of its actions.
+func (db gormDB) MergeTasks(keepId string, mergeId string, userId uint64) (*Task, error) {
+	if keepId == mergeId {
+		return nil, fmt.Errorf("Can't merge a task with itself")
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	task, err := tx.mergeTasks(keepId, mergeId, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (tx gormDB) mergeTasks(keepId string, mergeId string, userId uint64) (*Task, error) {
+	// Both tasks are locked so concurrent adds can't exceed the action limit
+	var tasks []Task
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id, kind").
+		Where("id IN (?) AND user_id = ?", []string{keepId, mergeId}, userId).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) < 2 {
+		return nil, ErrNotFound
+	}
+	if tasks[0].Kind != tasks[1].Kind {
+		return nil, &ValidationError{"mergeId", "must be the same kind as the kept task"}
+	}
+	var merged int
+	if err := tx.Model(&Action{}).Where("task_id = ?", mergeId).Count(&merged).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.checkActionLimit(keepId, merged); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&Action{}).Where("task_id = ?", mergeId).Update("task_id", keepId).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.moveAttachments(mergeId, keepId); err != nil {
+		return nil, err
+	}
+	if err := tx.moveDependencies(mergeId, keepId); err != nil {
+		return nil, err
+	}
+	if err := tx.touchTask(keepId); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&Task{}).Where("id = ? AND user_id = ?", mergeId, userId).UpdateColumn("deleted_at", tx.Now()).Error; err != nil {
+		return nil, err
+	}
+	task, err := tx.GetTask(keepId, userId, nil)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Validates the frequency that a habit would have after applying attrs.
+func (db gormDB) validateHabitUpdate(taskId string, userId uint64, attrs map[string]interface{}) error {
+	frequency, hasFrequency := attrs["frequency"].(int)
+	interval, hasInterval := attrs["interval"].(Interval)
+	if !hasFrequency && !hasInterval {
+		return nil
+	}
+
+	var current Task
+	if err := db.Where("id = ? AND user_id = ?", taskId, userId).First(&current).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+		return err
+	}
+	if current.Kind != HabitEnum {
+		return nil
+	}
+	if !hasFrequency {
+		frequency = current.Frequency
+	}
+	if !hasInterval {
+		interval = current.Interval
+	}
+	return validateFrequency(interval, frequency)
+}
+
 func (db gormDB) CreateUser(username string, password string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	if err != nil {
 		return nil, err
@@ -181,7 +1088,12 @@ func (db gormDB) CreateUser(username string, password string) (*User, error) {
 		HashedPassword: hashedPassword,
 	}
 
+	// The unique index catches usernames taken concurrently, so there's no
+	// need to look the username up first
 	err = db.Create(user).Error
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		return nil, ErrDuplicateUsername
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -198,6 +1110,32 @@ func (db gormDB) GetUserById(id uint64) (*User, error) {
 	return user, nil
 }
 
+// Deactivates a user by soft deleting them. Deactivated users can't log in or
+// authenticate with existing tokens or API keys, but their data is kept.
+func (db gormDB) DeactivateUser(id uint64) error {
+	result := db.Model(&User{}).Where("id = ?", id).UpdateColumn("deleted_at", db.Now())
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db gormDB) ReactivateUser(id uint64) error {
+	result := db.Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		UpdateColumn("deleted_at", nil)
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (db gormDB) GetUserByUsername(username string) (*User, error) {
 	user := &User{
 		Username: username,
@@ -208,15 +1146,164 @@ func (db gormDB) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
+// Returns the action with the given ID if its task belongs to the user.
+func (db gormDB) GetAction(id string, userId uint64) (*Action, error) {
+	action := &Action{
+		Id: id,
+	}
+	if err := db.Where(action).First(action).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	exists, err := db.TaskExists(action.TaskId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return action, nil
+}
+
 func (db gormDB) AddAction(action *Action, userId uint64) error {
-	task, err := db.GetTask(action.TaskId, userId, nil)
-	if task == nil {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	if err := tx.addAction(action, userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (tx gormDB) addAction(action *Action, userId uint64) error {
+	// Only the kind and recurrence are needed to validate the action, so skip
+	// loading actions. The task is locked so concurrent adds can't exceed the
+	// action limit.
+	var task Task
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id, kind, recurrence_rule").
+		Where("id = ? AND user_id = ?", action.TaskId, userId).
+		First(&task).Error
+	if err == gorm.ErrRecordNotFound {
 		return fmt.Errorf("Task %s does not exist for user %d", action.TaskId, userId)
 	}
 	if err != nil {
 		return err
 	}
-	return db.Create(action).Error
+	if err := validateAction(action, &task, tx.Now()); err != nil {
+		return err
+	}
+	if err := tx.validateCustomKind(action, userId); err != nil {
+		return err
+	}
+	if err := tx.checkActionLimit(task.Id, 1); err != nil {
+		return err
+	}
+	if err := tx.Create(action).Error; err != nil {
+		return err
+	}
+	return tx.touchTask(task.Id)
+}
+
+// Returns an ActionLimitError if adding more actions to a task would take it
+// past maxActionsPerTask. Callers lock the task first so concurrent adds
+// can't each see room for one more.
+func (tx gormDB) checkActionLimit(taskId string, adding int) error {
+	if maxActionsPerTask <= 0 {
+		return nil
+	}
+	var count int
+	if err := tx.Model(&Action{}).Where("task_id = ?", taskId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count+adding > maxActionsPerTask {
+		return &ActionLimitError{taskId, maxActionsPerTask}
+	}
+	return nil
+}
+
+// Marks a one-off task done after one of its completions is restored or
+// moved. Tasks can be marked done without a completion, so they're never
+// marked not done here. Habits have nothing to update since their streaks
+// and periods are computed from their actions as they're read.
+func (tx gormDB) recomputeDone(task *Task, action *Action) error {
+	if task.Kind != TaskEnum || action.Kind != ActionDone {
+		return nil
+	}
+	return tx.Model(&Task{}).Where("id = ? AND NOT done", task.Id).UpdateColumn("done", true).Error
+}
+
+// Bumps a task's updated_at after its actions change so that clients syncing
+// or caching by modification time see the change.
+func (db gormDB) touchTask(taskId string) error {
+	return db.Model(&Task{}).Where("id = ?", taskId).UpdateColumn("updated_at", db.Now()).Error
+}
+
+// Updates the when and note of one of the user's actions. Moving a
+// completion of a one-off task marks the task done again. Streaks and due
+// dates are computed from actions as they're read, so they follow an action
+// that moves into another period without anything else being updated.
+func (db gormDB) UpdateAction(id string, userId uint64, attrs map[string]interface{}) (*Action, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	action, err := tx.updateAction(id, userId, attrs)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+func (tx gormDB) updateAction(id string, userId uint64, attrs map[string]interface{}) (*Action, error) {
+	action, err := tx.GetAction(id, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]interface{})
+	for field, value := range attrs {
+		switch field {
+		case "when":
+			when, ok := value.(*time.Time)
+			if !ok || when == nil {
+				return nil, &ValidationError{"when", "is required"}
+			}
+			action.When = when
+			updates["when"] = when
+		case "note":
+			note, _ := value.(string)
+			action.Note = note
+			updates["note"] = note
+		default:
+			return nil, &ValidationError{field, "can't be updated"}
+		}
+	}
+	if len(updates) == 0 {
+		return action, nil
+	}
+
+	var task Task
+	if err := tx.Select("id, kind, recurrence_rule").Where("id = ?", action.TaskId).First(&task).Error; err != nil {
+		return nil, err
+	}
+	if err := validateAction(action, &task, tx.Now()); err != nil {
+		return nil, err
+	}
+	if err := tx.Model(action).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if _, moved := updates["when"]; moved {
+		if err := tx.recomputeDone(&task, action); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.touchTask(action.TaskId); err != nil {
+		return nil, err
+	}
+	return action, nil
 }
 
 func (db gormDB) DeleteAction(id string, userId uint64) error {
@@ -226,12 +1313,143 @@ func (db gormDB) DeleteAction(id string, userId uint64) error {
 	if err := db.Where(action).First(action).Error; err != nil {
 		return err
 	}
-	task, err := db.GetTask(action.TaskId, userId, nil)
+	exists, err := db.TaskExists(action.TaskId, userId)
 	if err != nil {
 		return err
 	}
-	if task == nil {
+	if !exists {
 		return fmt.Errorf("Not authorized to delete action %s", id)
 	}
-	return db.Delete(action).Error
+	if err := db.Model(action).UpdateColumn("deleted_at", db.Now()).Error; err != nil {
+		return err
+	}
+	return db.touchTask(action.TaskId)
+}
+
+// Restores a deleted action on one of the user's tasks. Restoring a
+// completion of a one-off task marks the task done again. Streaks and pending
+// counts are computed from live actions, so a restored completion of a habit
+// counts towards its period again without anything else being updated.
+func (db gormDB) RestoreAction(id string, userId uint64) (*Action, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	action, err := tx.restoreAction(id, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+func (tx gormDB) restoreAction(id string, userId uint64) (*Action, error) {
+	var action Action
+	err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&action).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The task is locked so concurrent adds can't exceed the action limit
+	var task Task
+	err = tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id, kind").
+		Where("id = ? AND user_id = ?", action.TaskId, userId).
+		First(&task).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.checkActionLimit(action.TaskId, 1); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Unscoped().Model(&action).UpdateColumn("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	action.DeletedAt = nil
+	if err := tx.recomputeDone(&task, &action); err != nil {
+		return nil, err
+	}
+	if err := tx.touchTask(action.TaskId); err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// Collapses actions on a task with the same kind and custom kind at the same
+// minute into one, keeping the earliest, and returns how many were removed.
+func (db gormDB) DeduplicateActions(taskId string, userId uint64) (int, error) {
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	removed, err := tx.deduplicateActions(taskId, userId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (tx gormDB) deduplicateActions(taskId string, userId uint64) (int, error) {
+	// Lock the task so actions added meanwhile wait for the dedup to finish
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id").
+		Where("id = ? AND user_id = ?", taskId, userId).
+		First(&Task{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	task, err := tx.GetTask(taskId, userId, nil)
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	type actionKey struct {
+		kind         ActionKind
+		customKindId uint64
+		minute       int64
+	}
+	seen := make(map[actionKey]bool)
+	var duplicateIds []string
+
+	actions := task.Actions
+	sort.Sort(actionsByWhen(actions))
+	for _, action := range actions {
+		key := actionKey{action.Kind, customKindIdOf(&action), action.When.Truncate(time.Minute).Unix()}
+		if seen[key] {
+			duplicateIds = append(duplicateIds, action.Id)
+		} else {
+			seen[key] = true
+		}
+	}
+	if len(duplicateIds) == 0 {
+		return 0, nil
+	}
+
+	result := tx.Model(&Action{}).Where("id IN (?)", duplicateIds).UpdateColumn("deleted_at", tx.Now())
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+	if err := tx.touchTask(taskId); err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected), nil
 }
+
+type actionsByWhen []Action
+
+func (a actionsByWhen) Len() int           { return len(a) }
+func (a actionsByWhen) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a actionsByWhen) Less(i, j int) bool { return a[i].When.Before(*a[j].When) }
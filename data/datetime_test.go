@@ -0,0 +1,91 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+func dateTimeScalar(t *testing.T) *graphql.Scalar {
+	db, _ := newFakeDatabase(t, systemClock{})
+	return GetSchema(db).Type("DateTime").(*graphql.Scalar)
+}
+
+func TestParseDateTime(t *testing.T) {
+	scalar := dateTimeScalar(t)
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"2017-01-10T12:00:00Z", time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)},
+		{"2017-01-10T12:00:00+02:00", time.Date(2017, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{"2017-01-10T12:00:00.5Z", time.Date(2017, 1, 10, 12, 0, 0, 5e8, time.UTC)},
+	}
+	for _, test := range tests {
+		parsed, ok := scalar.ParseValue(test.input).(*time.Time)
+		if !ok {
+			t.Errorf("%s: got %v, want a time", test.input, scalar.ParseValue(test.input))
+			continue
+		}
+		if !parsed.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.input, parsed, test.want)
+		}
+	}
+}
+
+func TestDateTimeRoundTrip(t *testing.T) {
+	scalar := dateTimeScalar(t)
+	when := time.Date(2017, 1, 10, 12, 30, 15, 0, time.UTC)
+	serialized := scalar.Serialize(&when)
+	parsed, ok := scalar.ParseValue(serialized).(*time.Time)
+	if !ok || !parsed.Equal(when) {
+		t.Errorf("%v serialized as %v parsed back as %v", when, serialized, scalar.ParseValue(serialized))
+	}
+}
+
+func TestParseDateTimeRejectsGarbage(t *testing.T) {
+	scalar := dateTimeScalar(t)
+	for _, input := range []interface{}{"", "yesterday", "2017-01-10", "2017-01-10 12:00:00", "1484049600", 1484049600, 1484049600.0, true} {
+		err, ok := scalar.ParseValue(input).(*ValidationError)
+		if !ok {
+			t.Errorf("%#v: got %v, want a validation error", input, scalar.ParseValue(input))
+			continue
+		}
+		if !strings.Contains(err.Error(), "RFC 3339") {
+			t.Errorf("%#v: got %q, want it to mention RFC 3339", input, err.Error())
+		}
+	}
+}
+
+func TestInvalidDateTimeArgument(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+	}{
+		{"variable", `query($since: DateTime!) { changes(since: $since) { deletedIds } }`, map[string]interface{}{"since": "yesterday"}},
+		{"unix time variable", `query($since: DateTime!) { changes(since: $since) { deletedIds } }`, map[string]interface{}{"since": 1484049600}},
+		{"literal", `{ changes(since: "yesterday") { deletedIds } }`, nil},
+		{"unix time literal", `{ changes(since: 1484049600) { deletedIds } }`, nil},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, systemClock{})
+		result := graphql.Do(graphql.Params{
+			Schema:         *GetSchema(db),
+			RequestString:  test.query,
+			VariableValues: test.variables,
+			Context:        context.WithValue(context.Background(), UserIdKey, uint64(1)),
+		})
+		if len(result.Errors) != 1 {
+			t.Errorf("%s: got errors %v, want one", test.name, result.Errors)
+		} else if message := result.Errors[0].Message; !strings.Contains(message, "RFC 3339") {
+			t.Errorf("%s: got %q, want it to mention RFC 3339", test.name, message)
+		}
+		if statements := conn.sent(); len(statements) > 0 {
+			t.Errorf("%s: reached the database in %s", test.name, statements[0].query)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TaskDependency blocks a task until the task it depends on is done. Only
+// one-off tasks have dependencies since habits are never finished.
+type TaskDependency struct {
+	TaskId          string    `json:"task_id" gorm:"primary_key;type:uuid"`
+	DependsOnTaskId string    `json:"depends_on_task_id" gorm:"primary_key;type:uuid;index"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Makes one of the user's tasks depend on another and returns the task,
+// rejecting dependencies that would make a task block itself. Adding an
+// existing dependency does nothing.
+func (db gormDB) AddDependency(taskId string, dependsOnTaskId string, userId uint64) (*Task, error) {
+	if taskId == dependsOnTaskId {
+		return nil, &ValidationError{"dependsOn", "a task can't depend on itself"}
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	if err := tx.addDependency(taskId, dependsOnTaskId, userId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return db.primary().GetTask(taskId, userId, nil)
+}
+
+func (tx gormDB) addDependency(taskId string, dependsOnTaskId string, userId uint64) error {
+	// Lock the user so concurrent adds can't each close half of a cycle
+	var user User
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").Where("id = ?", userId).First(&user).Error
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.Model(&Task{}).
+		Where("id IN (?) AND user_id = ? AND kind = ?", []string{taskId, dependsOnTaskId}, userId, TaskEnum).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return ErrNotFound
+	}
+
+	// A cycle would be formed if the new dependency already depends on the
+	// task, directly or through other tasks
+	cycle, err := tx.dependsOn(dependsOnTaskId, taskId)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return &ValidationError{"dependsOn", "the task would end up depending on itself"}
+	}
+
+	dependency := TaskDependency{TaskId: taskId, DependsOnTaskId: dependsOnTaskId}
+	err = tx.Where(dependency).First(&TaskDependency{}).Error
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return tx.Create(&dependency).Error
+}
+
+// Returns whether a task depends on another, directly or through other tasks.
+func (db gormDB) dependsOn(taskId string, dependsOnTaskId string) (bool, error) {
+	var count int
+	err := db.Raw(`WITH RECURSIVE reachable(id) AS (
+			SELECT depends_on_task_id FROM task_dependencies WHERE task_id = ?
+			UNION
+			SELECT task_dependencies.depends_on_task_id FROM task_dependencies
+			JOIN reachable ON task_dependencies.task_id = reachable.id
+		)
+		SELECT count(*) FROM reachable WHERE id = ?`, taskId, dependsOnTaskId).
+		Row().Scan(&count)
+	return count > 0, err
+}
+
+// Moves the dependencies of a task being merged away onto the task it's
+// merged into. Dependencies the kept task already has and ones between the
+// two tasks are dropped, and the move is rejected if it would form a cycle.
+func (tx gormDB) moveDependencies(fromId string, toId string) error {
+	err := tx.Where("task_id = ? AND (depends_on_task_id = ? OR depends_on_task_id IN (SELECT depends_on_task_id FROM task_dependencies WHERE task_id = ?))", fromId, toId, toId).
+		Delete(&TaskDependency{}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Where("depends_on_task_id = ? AND (task_id = ? OR task_id IN (SELECT task_id FROM task_dependencies WHERE depends_on_task_id = ?))", fromId, toId, toId).
+		Delete(&TaskDependency{}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&TaskDependency{}).Where("task_id = ?", fromId).Update("task_id", toId).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&TaskDependency{}).Where("depends_on_task_id = ?", fromId).Update("depends_on_task_id", toId).Error; err != nil {
+		return err
+	}
+
+	cycle, err := tx.dependsOn(toId, toId)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return &ValidationError{"mergeId", "merging would make the task depend on itself"}
+	}
+	return nil
+}
+
+// Removes a dependency between two of the user's tasks and returns the task.
+func (db gormDB) RemoveDependency(taskId string, dependsOnTaskId string, userId uint64) (*Task, error) {
+	result := db.Where("task_id = ? AND depends_on_task_id = ? AND task_id IN (SELECT id FROM tasks WHERE user_id = ?)", taskId, dependsOnTaskId, userId).
+		Delete(&TaskDependency{})
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return db.primary().GetTask(taskId, userId, nil)
+}
+
+// Returns whether any of the tasks a task depends on isn't done yet. Deleted
+// tasks don't block anything.
+func (db gormDB) IsTaskBlocked(taskId string, userId uint64) (bool, error) {
+	var count int
+	err := db.Table("task_dependencies").
+		Joins("JOIN tasks ON tasks.id = task_dependencies.depends_on_task_id").
+		Where("task_dependencies.task_id = ? AND tasks.user_id = ? AND NOT tasks.done AND tasks.deleted_at IS NULL", taskId, userId).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Returns the IDs of the tasks a task depends on.
+func (db gormDB) GetDependencyIds(taskId string, userId uint64) ([]string, error) {
+	ids := []string{}
+	err := db.Table("task_dependencies").
+		Joins("JOIN tasks ON tasks.id = task_dependencies.task_id").
+		Where("task_dependencies.task_id = ? AND tasks.user_id = ?", taskId, userId).
+		Order("task_dependencies.created_at").
+		Pluck("task_dependencies.depends_on_task_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A database/sql driver that records the statements it's sent and answers
+// queries with canned rows, so the data layer can be tested without Postgres.
+type fakeDriver struct{}
+
+func init() {
+	sql.Register("duetfake", fakeDriver{})
+}
+
+var fakeConns = struct {
+	sync.Mutex
+	byName map[string]*fakeConn
+}{byName: make(map[string]*fakeConn)}
+
+// A statement sent to a fake database.
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// The rows returned for queries containing a fragment of SQL.
+type fakeResult struct {
+	fragment string
+	columns  []string
+	rows     [][]driver.Value
+}
+
+// The state shared by every connection to one fake database.
+type fakeConn struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+	results    []fakeResult
+	// Errors that statements containing each fragment fail with
+	failures map[string]error
+}
+
+// Opens a Database backed by a fake connection and reading the time from
+// clock. Queries are answered by the first result whose fragment they
+// contain, or with no rows.
+func newFakeDatabase(t *testing.T, clock Clock, results ...fakeResult) (Database, *fakeConn) {
+	db, conn := openFake(t, results...)
+	return NewDatabase(db, nil, clock), conn
+}
+
+// Opens a gorm handle to a new fake database answering queries with results.
+func openFake(t *testing.T, results ...fakeResult) (*gorm.DB, *fakeConn) {
+	conn := &fakeConn{results: results}
+	fakeConns.Lock()
+	name := fmt.Sprintf("fake%d", len(fakeConns.byName))
+	fakeConns.byName[name] = conn
+	fakeConns.Unlock()
+
+	sqlDB, err := sql.Open("duetfake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("postgres", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, conn
+}
+
+// Returns the statements sent so far.
+func (c *fakeConn) sent() []fakeStatement {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]fakeStatement(nil), c.statements...)
+}
+
+// Replaces the results queries are answered with, such as after a statement
+// that would have changed them.
+func (c *fakeConn) setResults(results ...fakeResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = results
+}
+
+// Makes statements containing fragment fail with err.
+func (c *fakeConn) failOn(fragment string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == nil {
+		c.failures = make(map[string]error)
+	}
+	c.failures[fragment] = err
+}
+
+func (c *fakeConn) record(query string, args []driver.Value) (fakeResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements = append(c.statements, fakeStatement{query, args})
+	for fragment, err := range c.failures {
+		if strings.Contains(query, fragment) {
+			return fakeResult{}, err
+		}
+	}
+	for _, result := range c.results {
+		if strings.Contains(query, result.fragment) {
+			return result, nil
+		}
+	}
+	return fakeResult{}, nil
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeConns.Lock()
+	defer fakeConns.Unlock()
+	conn, ok := fakeConns.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database named %s", name)
+	}
+	return fakeSession{conn}, nil
+}
+
+type fakeSession struct {
+	conn *fakeConn
+}
+
+func (s fakeSession) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{s.conn, query}, nil
+}
+
+func (fakeSession) Close() error              { return nil }
+func (fakeSession) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.conn.record(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result, err := s.conn.record(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: result}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
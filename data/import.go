@@ -0,0 +1,105 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// Adds actions recorded offline, skipping those that duplicate an existing
+// action or an earlier one in the batch. Actions are duplicates when they're
+// on the same task with the same kind and custom kind in the same minute. Either every
+// non-duplicate action is added or, if any is invalid, none are.
+func (db gormDB) ImportActions(userId uint64, actions []*Action) (int, int, error) {
+	if len(actions) == 0 {
+		return 0, 0, nil
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	imported, skipped, err := tx.importActions(userId, actions)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, 0, err
+	}
+	return imported, skipped, nil
+}
+
+func (tx gormDB) importActions(userId uint64, actions []*Action) (int, int, error) {
+	taskIds := make([]string, 0, len(actions))
+	for _, action := range actions {
+		taskIds = append(taskIds, action.TaskId)
+	}
+
+	// The tasks are locked so concurrent adds can't exceed the action limit
+	var owned []Task
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Select("id, kind, recurrence_rule").
+		Where("id IN (?) AND user_id = ?", taskIds, userId).
+		Find(&owned).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	tasks := make(map[string]*Task, len(owned))
+	for i := range owned {
+		tasks[owned[i].Id] = &owned[i]
+	}
+
+	now := tx.Now()
+	for _, action := range actions {
+		task, ok := tasks[action.TaskId]
+		if !ok {
+			return 0, 0, fmt.Errorf("Task %s does not exist for user %d", action.TaskId, userId)
+		}
+		if err := validateAction(action, task, now); err != nil {
+			return 0, 0, err
+		}
+		if err := tx.validateCustomKind(action, userId); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var existing []Action
+	if err := tx.Select(`task_id, kind, custom_kind_id, "when"`).Where("task_id IN (?)", taskIds).Find(&existing).Error; err != nil {
+		return 0, 0, err
+	}
+	type actionKey struct {
+		taskId       string
+		kind         ActionKind
+		customKindId uint64
+		minute       int64
+	}
+	seen := make(map[actionKey]bool)
+	counts := make(map[string]int)
+	for _, action := range existing {
+		seen[actionKey{action.TaskId, action.Kind, customKindIdOf(&action), action.When.Truncate(time.Minute).Unix()}] = true
+		counts[action.TaskId]++
+	}
+
+	imported, skipped := 0, 0
+	touched := make(map[string]bool)
+	for _, action := range actions {
+		key := actionKey{action.TaskId, action.Kind, customKindIdOf(action), action.When.Truncate(time.Minute).Unix()}
+		if seen[key] {
+			skipped++
+			continue
+		}
+		seen[key] = true
+		if maxActionsPerTask > 0 && counts[action.TaskId] >= maxActionsPerTask {
+			return 0, 0, &ActionLimitError{action.TaskId, maxActionsPerTask}
+		}
+		if err := tx.Create(action).Error; err != nil {
+			return 0, 0, err
+		}
+		counts[action.TaskId]++
+		touched[action.TaskId] = true
+		imported++
+	}
+
+	for taskId := range touched {
+		if err := tx.touchTask(taskId); err != nil {
+			return 0, 0, err
+		}
+	}
+	return imported, skipped, nil
+}
@@ -0,0 +1,254 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SkippedItem is an item of an export that wasn't imported.
+type SkippedItem struct {
+	// The position of the item in the export
+	Index  int    `json:"index"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+// A task read from an export along with its position in the export.
+type externalItem struct {
+	index int
+	task  *Task
+}
+
+// An externalFormat reads the tasks in an export from another app.
+type externalFormat interface {
+	// Returns the tasks that could be read and the items that couldn't.
+	parse(r io.Reader) ([]externalItem, []SkippedItem, error)
+}
+
+var externalFormats = map[string]externalFormat{
+	"todoist": todoistFormat{},
+	"ics":     icsFormat{},
+}
+
+// The largest export and the most tasks that can be imported at once.
+const maxImportSize = 5 << 20
+const maxImportTasks = 1000
+
+// HandleImportExternal adds the tasks in an export from another app, sent as
+// the request body, to the user's tasks. The format query parameter selects
+// the app, either todoist for a Todoist JSON export or ics for an iCalendar
+// file of VTODOs. Items that can't be read or are invalid are skipped and
+// reported in the response.
+func HandleImportExternal(db Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Imports must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		userId, err := AuthRequest(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		format, ok := externalFormats[r.URL.Query().Get("format")]
+		if !ok {
+			http.Error(w, "Unsupported import format, expected todoist or ics", http.StatusBadRequest)
+			return
+		}
+
+		items, skipped, err := format.parse(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(items) > maxImportTasks {
+			http.Error(w, fmt.Sprintf("Imports can have at most %d tasks", maxImportTasks), http.StatusBadRequest)
+			return
+		}
+
+		tasks := make([]*Task, 0, len(items))
+		for _, item := range items {
+			tasks = append(tasks, item.task)
+		}
+		created, invalid, err := db.AddTasks(tasks, userId, true)
+		if err != nil {
+			log.Printf("Error importing tasks for user %d: %s", userId, err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, taskErr := range invalid {
+			item := items[taskErr.Index]
+			skipped = append(skipped, SkippedItem{item.index, item.task.Title, taskErr.Error()})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"imported": len(created),
+			"skipped":  skipped,
+		})
+	})
+}
+
+// Reads the items of a Todoist JSON export, which has the same shape as a
+// sync response.
+type todoistFormat struct{}
+
+func (todoistFormat) parse(r io.Reader) ([]externalItem, []SkippedItem, error) {
+	export := TodoistSync{}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, nil, fmt.Errorf("Invalid Todoist export: %s", err.Error())
+	}
+
+	items := []externalItem{}
+	skipped := []SkippedItem{}
+	for i, item := range export.Items {
+		var endDate *time.Time
+		if item.DueDate != "" {
+			due, err := time.Parse(todoistDueDateLayout, item.DueDate)
+			if err != nil {
+				skipped = append(skipped, SkippedItem{i, item.Title, fmt.Sprintf("invalid due date \"%s\"", item.DueDate)})
+				continue
+			}
+			endDate = &due
+		}
+		items = append(items, externalItem{i, &Task{
+			Kind:    TaskEnum,
+			Title:   item.Title,
+			Done:    item.Checked == 1,
+			EndDate: endDate,
+		}})
+	}
+	return items, skipped, nil
+}
+
+// Reads the VTODO components of an iCalendar file. Other components such as
+// events are ignored.
+type icsFormat struct{}
+
+func (icsFormat) parse(r io.Reader) ([]externalItem, []SkippedItem, error) {
+	lines, err := icsLines(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := []externalItem{}
+	skipped := []SkippedItem{}
+	var task *Task
+	var reason string
+	index := 0
+	for _, line := range lines {
+		name, params, value := icsProperty(line)
+		if name == "BEGIN" && value == "VTODO" {
+			task = &Task{Kind: TaskEnum}
+			reason = ""
+			continue
+		}
+		if task == nil {
+			continue
+		}
+
+		switch name {
+		case "END":
+			if value != "VTODO" {
+				continue
+			}
+			if reason != "" {
+				skipped = append(skipped, SkippedItem{index, task.Title, reason})
+			} else {
+				items = append(items, externalItem{index, task})
+			}
+			task = nil
+			index++
+		case "SUMMARY":
+			task.Title = icsUnescape(value)
+		case "DTSTART", "DUE":
+			t, err := icsTime(params, value)
+			if err != nil {
+				reason = fmt.Sprintf("invalid %s \"%s\"", strings.ToLower(name), value)
+				continue
+			}
+			if name == "DTSTART" {
+				task.StartDate = &t
+			} else {
+				task.EndDate = &t
+			}
+		case "STATUS":
+			task.Done = value == "COMPLETED"
+		case "COMPLETED":
+			task.Done = true
+		}
+	}
+	if task != nil {
+		return nil, nil, fmt.Errorf("Invalid iCalendar file: a VTODO isn't ended")
+	}
+	return items, skipped, nil
+}
+
+// Returns the content lines of an iCalendar file with folded lines joined.
+func icsLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Invalid iCalendar file: %s", err.Error())
+	}
+	return lines, nil
+}
+
+// Splits a content line like DUE;VALUE=DATE:20170102 into its name,
+// parameters and value.
+func icsProperty(line string) (string, map[string]string, string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, param := range parts[1:] {
+		if eq := strings.Index(param, "="); eq >= 0 {
+			params[strings.ToUpper(param[:eq])] = strings.Trim(param[eq+1:], "\"")
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+// Parses an iCalendar date or date-time. Times without a zone are taken to
+// be in the TZID parameter's zone, or UTC if there isn't one.
+func icsTime(params map[string]string, value string) (time.Time, error) {
+	loc := time.UTC
+	if tzid, ok := params["TZID"]; ok {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, err
+		}
+	}
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		return time.ParseInLocation("20060102T150405", value, loc)
+	default:
+		return time.ParseInLocation("20060102", value, loc)
+	}
+}
+
+var icsEscapes = strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n")
+
+func icsUnescape(value string) string {
+	return icsEscapes.Replace(value)
+}
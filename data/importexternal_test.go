@@ -0,0 +1,173 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const todoistExport = `{
+	"sync_token": "abc",
+	"items": [
+		{"content": "Buy milk", "due_date_utc": "Tue 10 Jan 2017 12:00:00 +0000", "checked": 0},
+		{"content": "Call mom", "checked": 1},
+		{"content": "Pay rent", "due_date_utc": "next tuesday", "checked": 0}
+	]
+}`
+
+const icsExport = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Not a todo\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"SUMMARY:Buy milk\\, eggs\r\n" +
+	"DTSTART;VALUE=DATE:20170109\r\n" +
+	"DUE:20170110T120000Z\r\n" +
+	"END:VTODO\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"SUMMARY:Call\r\n" +
+	"  mom\r\n" +
+	"STATUS:COMPLETED\r\n" +
+	"END:VTODO\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"SUMMARY:Pay rent\r\n" +
+	"DUE:soon\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+// Returns a description of an imported task for comparisons.
+func describeImported(item externalItem) string {
+	description := item.task.Title
+	if item.task.StartDate != nil {
+		description += " from " + item.task.StartDate.Format(time.RFC3339)
+	}
+	if item.task.EndDate != nil {
+		description += " due " + item.task.EndDate.Format(time.RFC3339)
+	}
+	if item.task.Done {
+		description += " done"
+	}
+	return description
+}
+
+func TestParseExternalFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		export  string
+		tasks   []string
+		skipped []SkippedItem
+	}{
+		{"todoist", todoistExport,
+			[]string{"Buy milk due 2017-01-10T12:00:00Z", "Call mom done"},
+			[]SkippedItem{{2, "Pay rent", `invalid due date "next tuesday"`}}},
+		{"ics", icsExport,
+			[]string{"Buy milk, eggs from 2017-01-09T00:00:00Z due 2017-01-10T12:00:00Z", "Call mom done"},
+			[]SkippedItem{{2, "Pay rent", `invalid due "soon"`}}},
+	}
+	for _, test := range tests {
+		items, skipped, err := externalFormats[test.format].parse(strings.NewReader(test.export))
+		if err != nil {
+			t.Errorf("%s: %s", test.format, err)
+			continue
+		}
+		var tasks []string
+		for i, item := range items {
+			if item.index != i || item.task.Kind != TaskEnum {
+				t.Errorf("%s: got item %d at index %d of kind %d, want a task", test.format, i, item.index, item.task.Kind)
+			}
+			tasks = append(tasks, describeImported(item))
+		}
+		if strings.Join(tasks, "\n") != strings.Join(test.tasks, "\n") {
+			t.Errorf("%s: got tasks %q, want %q", test.format, tasks, test.tasks)
+		}
+		if len(skipped) != len(test.skipped) {
+			t.Errorf("%s: got skipped %+v, want %+v", test.format, skipped, test.skipped)
+			continue
+		}
+		for i := range skipped {
+			if skipped[i] != test.skipped[i] {
+				t.Errorf("%s: got skipped %+v, want %+v", test.format, skipped[i], test.skipped[i])
+			}
+		}
+	}
+}
+
+func TestParseInvalidExports(t *testing.T) {
+	tests := []struct {
+		format string
+		export string
+	}{
+		{"todoist", `{"items": [`},
+		{"ics", "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nSUMMARY:Buy milk\r\n"},
+	}
+	for _, test := range tests {
+		if _, _, err := externalFormats[test.format].parse(strings.NewReader(test.export)); err == nil {
+			t.Errorf("%s: got no error for %q", test.format, test.export)
+		}
+	}
+}
+
+func TestImportExternal(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`INSERT INTO "tasks"`, []string{"id"}, [][]driver.Value{{"new"}}},
+		fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}},
+		fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+	)
+	// The blank title is only rejected when the tasks are validated
+	export := strings.Replace(todoistExport, `"Call mom"`, `"  "`, 1)
+	r := httptest.NewRequest("POST", "/import/external?format=todoist", strings.NewReader(export))
+	for name, value := range bearer(t, &User{Id: 1}) {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	HandleImportExternal(db).ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var body struct {
+		Imported int
+		Skipped  []SkippedItem
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Imported != 1 || len(body.Skipped) != 2 || body.Skipped[0].Index != 2 || body.Skipped[1].Index != 1 {
+		t.Errorf("got %+v, want 1 imported with the bad due date and blank title skipped", body)
+	}
+	inserted := 0
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, `INSERT INTO "tasks"`) {
+			inserted++
+			if !hasArg(statement.args, "Buy milk") || !hasArg(statement.args, int64(1)) {
+				t.Errorf("got %v, want Buy milk added for user 1", statement.args)
+			}
+		}
+	}
+	if inserted != 1 {
+		t.Errorf("got %d tasks inserted, want 1", inserted)
+	}
+}
+
+func TestImportExternalUnknownFormat(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}})
+	r := httptest.NewRequest("POST", "/import/external?format=csv", strings.NewReader("title\nBuy milk\n"))
+	for name, value := range bearer(t, &User{Id: 1}) {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	HandleImportExternal(db).ServeHTTP(w, r)
+	if w.Code != 400 {
+		t.Errorf("got status %d, want 400", w.Code)
+	}
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, "INSERT") {
+			t.Errorf("got %s for an unsupported format", statement.query)
+		}
+	}
+}
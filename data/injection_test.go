@@ -0,0 +1,217 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+var hostileInputs = []string{
+	"'; DROP TABLE tasks; --",
+	"1 OR 1=1",
+}
+
+// Fails the test if any statement sent to the fake database has the input in
+// its SQL rather than only in its arguments.
+func checkNotInSql(t *testing.T, name string, conn *fakeConn, input string) {
+	for _, statement := range conn.sent() {
+		if strings.Contains(statement.query, input) {
+			t.Errorf("%s: %q reached the SQL of %s", name, input, statement.query)
+		}
+	}
+}
+
+// Returns whether the input was sent as one of the arguments of a statement.
+func sentAsArg(conn *fakeConn, input string) bool {
+	for _, statement := range conn.sent() {
+		for _, arg := range statement.args {
+			if arg == input {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestAllActionsFilterInjection(t *testing.T) {
+	query := `query($kind: ActionKind, $from: DateTime, $to: DateTime) {
+		allActions(kind: $kind, from: $from, to: $to) { id }
+	}`
+	for _, input := range hostileInputs {
+		for _, variable := range []string{"kind", "from", "to"} {
+			db, conn := newFakeDatabase(t, systemClock{})
+			result := graphql.Do(graphql.Params{
+				Schema:         *GetSchema(db),
+				RequestString:  query,
+				VariableValues: map[string]interface{}{variable: input},
+				Context:        context.WithValue(context.Background(), UserIdKey, uint64(1)),
+			})
+			if len(result.Errors) == 0 {
+				t.Errorf("%s: %q was accepted", variable, input)
+			}
+			if statements := conn.sent(); len(statements) > 0 {
+				t.Errorf("%s: %q reached the database in %s", variable, input, statements[0].query)
+			}
+		}
+	}
+}
+
+func TestActionFilterIsParameterized(t *testing.T) {
+	kind := ActionDone
+	from := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	db, conn := newFakeDatabase(t, systemClock{})
+	if _, err := db.GetAllActions(1, ActionFilter{Kind: &kind, From: &from, To: &to, Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	statements := conn.sent()
+	if len(statements) != 1 {
+		t.Fatalf("got %d statements, want 1", len(statements))
+	}
+	statement := statements[0]
+	for _, value := range []string{from.Format("2006-01-02"), to.Format("2006-01-02")} {
+		if strings.Contains(statement.query, value) {
+			t.Errorf("%s reached the SQL of %s", value, statement.query)
+		}
+	}
+	found := 0
+	for _, arg := range statement.args {
+		if when, ok := arg.(time.Time); ok && (when.Equal(from) || when.Equal(to)) {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("got %d of from and to as arguments, want 2: %v", found, statement.args)
+	}
+}
+
+func TestModifiedSinceInjection(t *testing.T) {
+	for _, input := range hostileInputs {
+		db, conn := newFakeDatabase(t, systemClock{})
+		result := graphql.Do(graphql.Params{
+			Schema:         *GetSchema(db),
+			RequestString:  `query($since: DateTime!) { changes(since: $since) { deletedIds } }`,
+			VariableValues: map[string]interface{}{"since": input},
+			Context:        context.WithValue(context.Background(), UserIdKey, uint64(1)),
+		})
+		if len(result.Errors) == 0 {
+			t.Errorf("%q was accepted as since", input)
+		}
+		if statements := conn.sent(); len(statements) > 0 {
+			t.Errorf("%q reached the database in %s", input, statements[0].query)
+		}
+	}
+}
+
+func TestModifiedSinceIsParameterized(t *testing.T) {
+	since := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	db, conn := newFakeDatabase(t, systemClock{})
+	if _, err := db.GetTasksModifiedSince(1, since); err != nil {
+		t.Fatal(err)
+	}
+	statements := conn.sent()
+	if len(statements) == 0 {
+		t.Fatal("no statements were sent")
+	}
+	found := 0
+	for _, arg := range statements[0].args {
+		if when, ok := arg.(time.Time); ok && when.Equal(since) {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("got since as %d arguments, want 2: %v", found, statements[0].args)
+	}
+}
+
+func TestUpdateTaskInjection(t *testing.T) {
+	for _, input := range hostileInputs {
+		// Fields outside of the allowlist are rejected before any SQL is built
+		db, conn := newFakeDatabase(t, systemClock{})
+		_, err := db.UpdateTask("t", 1, map[string]interface{}{input: "x"})
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("updating the field %q: got %v, want a ValidationError", input, err)
+		}
+		if statements := conn.sent(); len(statements) > 0 {
+			t.Errorf("updating the field %q reached the database in %s", input, statements[0].query)
+		}
+
+		// Values and task IDs are only ever sent as arguments, if they're
+		// valid at all
+		tests := []struct {
+			name     string
+			taskId   string
+			attrs    map[string]interface{}
+			rejected bool
+		}{
+			{"title", "t", map[string]interface{}{"title": input}, false},
+			{"task ID", input, map[string]interface{}{"title": "Title"}, false},
+			{"color", "t", map[string]interface{}{"color": input}, true},
+			{"recurrence rule", "t", map[string]interface{}{"recurrence_rule": input}, true},
+		}
+		for _, test := range tests {
+			db, conn := newFakeDatabase(t, systemClock{})
+			_, err := db.UpdateTask(test.taskId, 1, test.attrs)
+			if test.rejected {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Errorf("%s %q: got %v, want a ValidationError", test.name, input, err)
+				}
+				if statements := conn.sent(); len(statements) > 0 {
+					t.Errorf("%s %q reached the database in %s", test.name, input, statements[0].query)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %q: %s", test.name, input, err)
+				continue
+			}
+			checkNotInSql(t, test.name, conn, input)
+			if !sentAsArg(conn, input) {
+				t.Errorf("%s: %q wasn't sent as an argument", test.name, input)
+			}
+		}
+	}
+}
+
+func TestStringInputInjection(t *testing.T) {
+	// Lets the methods that lock a row first get as far as using the input
+	userRow := fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}}
+	taskRow := fakeResult{`FROM "tasks"`, []string{"id"}, [][]driver.Value{{"t"}}}
+	noneCounted := fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}}
+	tests := []struct {
+		name    string
+		run     func(db Database, input string)
+		results []fakeResult
+	}{
+		{"GetTask", func(db Database, input string) { db.GetTask(input, 1, nil) }, nil},
+		{"GetTasksByIds", func(db Database, input string) { db.GetTasksByIds([]string{input}, 1) }, nil},
+		{"DeleteTask", func(db Database, input string) { db.DeleteTask(input, 1) }, nil},
+		{"RestoreTask", func(db Database, input string) { db.RestoreTask(input, 1) }, nil},
+		{"PinTask", func(db Database, input string) { db.PinTask(input, 1) }, nil},
+		{"SetTasksDone", func(db Database, input string) { db.SetTasksDone([]string{input}, 1, true) }, nil},
+		{"MergeTasks", func(db Database, input string) { db.MergeTasks("t", input, 1) }, nil},
+		{"GetUserByUsername", func(db Database, input string) { db.GetUserByUsername(input) }, nil},
+		{"CreateUser", func(db Database, input string) { db.CreateUser(input, "password") }, nil},
+		{"GetAction", func(db Database, input string) { db.GetAction(input, 1) }, nil},
+		{"DeleteAction", func(db Database, input string) { db.DeleteAction(input, 1) }, nil},
+		{"CreateApiKey", func(db Database, input string) { db.CreateApiKey(1, input) }, nil},
+		{"CreateActionKind", func(db Database, input string) { db.CreateActionKind(1, input) }, nil},
+		{"AddAttachment", func(db Database, input string) { db.AddAttachment("t", 1, "https://example.com/", input) }, []fakeResult{taskRow, noneCounted}},
+		{"AddDependency", func(db Database, input string) { db.AddDependency("t", input, 1) }, []fakeResult{userRow}},
+		{"RemoveDependency", func(db Database, input string) { db.RemoveDependency("t", input, 1) }, nil},
+	}
+	for _, input := range hostileInputs {
+		for _, test := range tests {
+			db, conn := newFakeDatabase(t, systemClock{}, test.results...)
+			test.run(db, input)
+			checkNotInSql(t, test.name, conn, input)
+			if !sentAsArg(conn, input) {
+				t.Errorf("%s: %q wasn't sent as an argument", test.name, input)
+			}
+		}
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
         "os"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/andyzg/duet/config"
 )
 
 type usernameAndPassword struct {
@@ -26,12 +30,35 @@ type signupInfo struct {
 
 type DuetClaims struct {
 	jwt.StandardClaims
+	Username string `json:"username,omitempty"`
+	Admin    bool   `json:"admin,omitempty"`
+	// When the user logged in, which refreshed tokens keep so that sessions
+	// can't be extended past their max lifetime
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// Set on refresh tokens, which can only be exchanged for new tokens and
+	// not used to authenticate
+	Refresh bool `json:"refresh,omitempty"`
 }
 
 var tokenSecret []byte = []byte(os.Getenv("JWT_SECRET"))
 
 var bcryptCost int = 10
 
+// How long a token lasts, or 0 for tokens that never expire. Tokens used
+// within the refresh window before they expire are replaced with ones that
+// last the full timeout again, so active sessions only end once they reach
+// the max lifetime.
+var sessionTimeout = config.Duration("DUET_SESSION_TIMEOUT", 0)
+var sessionRefreshWindow = config.Duration("DUET_SESSION_REFRESH_WINDOW", time.Hour)
+var sessionMaxLifetime = config.Duration("DUET_SESSION_MAX_LIFETIME", 30*24*time.Hour)
+
+// The response header refreshed tokens are sent in.
+const refreshedTokenHeader = "X-Refreshed-Token"
+
+// Returned for both unknown users and wrong passwords so that usernames can't
+// be discovered by logging in.
+var errInvalidCredentials = fmt.Errorf("Invalid username or password")
+
 func ServeCreateUser(db Database) func(rest.ResponseWriter, *rest.Request) {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		userAndPass := usernameAndPassword{}
@@ -42,6 +69,14 @@ func ServeCreateUser(db Database) func(rest.ResponseWriter, *rest.Request) {
 		}
 
 		user, err := db.CreateUser(userAndPass.Username, userAndPass.Password)
+		if err == ErrDuplicateUsername {
+			rest.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if _, ok := err.(*ValidationError); ok {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			rest.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -75,11 +110,7 @@ func ServeLogin(db Database) func(rest.ResponseWriter, *rest.Request) {
 }
 
 func Login(db Database, username string, password string) (string, error) {
-	user, err := db.GetUserByUsername(username)
-	if err != nil {
-		return "", err
-	}
-	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
+	user, err := authenticate(db, username, password)
 	if err != nil {
 		return "", err
 	}
@@ -87,12 +118,37 @@ func Login(db Database, username string, password string) (string, error) {
 	// TODO don't log password
 	log.Printf("Username: %s, Password: %s\n", username, password)
 
+	return newToken(user, time.Now())
+}
+
+// Returns the user with the username if the password is theirs.
+func authenticate(db Database, username string, password string) (*User, error) {
+	user, err := db.GetUserByUsername(strings.TrimSpace(username))
+	if err != nil {
+		return nil, err
+	}
+	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Signs a token whose claims reflect the user's current profile for a
+// session that started at authTime.
+func newToken(user *User, authTime time.Time) (string, error) {
+	now := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, DuetClaims{
-		jwt.StandardClaims{
-			Subject:  strconv.FormatUint(user.Id, 10),
-			Issuer:   "Duet",
-			Audience: "https://api.helloduet.com",
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(user.Id, 10),
+			Issuer:    "Duet",
+			Audience:  "https://api.helloduet.com",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: sessionExpiry(authTime, now),
 		},
+		Username: user.Username,
+		Admin:    user.Admin,
+		AuthTime: authTime.Unix(),
 	})
 
 	tokenString, err := token.SignedString(tokenSecret)
@@ -102,6 +158,116 @@ func Login(db Database, username string, password string) (string, error) {
 	return tokenString, nil
 }
 
+// Signs a refresh token for a session that started at authTime. It lasts
+// for the session's max lifetime, so clients can get new tokens once theirs
+// expire without asking for the password again.
+func newRefreshToken(user *User, authTime time.Time) (string, error) {
+	var expiresAt int64
+	if sessionMaxLifetime > 0 {
+		expiresAt = authTime.Add(sessionMaxLifetime).Unix()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, DuetClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(user.Id, 10),
+			Issuer:    "Duet",
+			Audience:  "https://api.helloduet.com",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt,
+		},
+		AuthTime: authTime.Unix(),
+		Refresh:  true,
+	})
+	return token.SignedString(tokenSecret)
+}
+
+// Returns when a token issued at now for a session that started at authTime
+// expires as a Unix time, or 0 if tokens don't expire.
+func sessionExpiry(authTime time.Time, now time.Time) int64 {
+	if sessionTimeout <= 0 {
+		return 0
+	}
+	expiry := now.Add(sessionTimeout)
+	if sessionMaxLifetime > 0 && expiry.After(authTime.Add(sessionMaxLifetime)) {
+		expiry = authTime.Add(sessionMaxLifetime)
+	}
+	return expiry.Unix()
+}
+
+// Returns a replacement for a token that expires within the refresh window,
+// or "" if it isn't due for one or its session can't be extended further.
+func refreshToken(claims DuetClaims, now time.Time) (string, error) {
+	if claims.ExpiresAt == 0 || claims.AuthTime == 0 {
+		return "", nil
+	}
+	if time.Unix(claims.ExpiresAt, 0).Sub(now) > sessionRefreshWindow {
+		return "", nil
+	}
+	expiry := sessionExpiry(time.Unix(claims.AuthTime, 0), now)
+	if expiry <= claims.ExpiresAt {
+		return "", nil
+	}
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expiry
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokenSecret)
+}
+
+// SlideSession sends a refreshed token in the X-Refreshed-Token header when
+// the request's token is close to expiring, which clients should use from
+// then on. Call it only once the request is authenticated and the user is
+// known to be active, so deactivated users' sessions aren't kept alive.
+func SlideSession(header http.Header, r *http.Request) {
+	// Checked here rather than with GetBearerToken, which logs requests
+	// authenticated some other way
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return
+	}
+	claims, err := VerifyToken(strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		return
+	}
+	refreshed, err := refreshToken(*claims, time.Now())
+	if err != nil {
+		log.Printf("Error refreshing token: %s", err.Error())
+	} else if refreshed != "" {
+		header.Set(refreshedTokenHeader, refreshed)
+	}
+}
+
+// Returns a fresh token for the authenticated user so that clients can pick
+// up profile changes such as a new username without logging in again.
+func ServeReissueToken(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := restUserId(db, w, r)
+		if !ok {
+			return
+		}
+
+		user, err := db.GetUserById(userId)
+		if err != nil {
+			log.Printf("Error loading user %d to reissue token: %s", userId, err.Error())
+			rest.Error(w, "User does not exist", http.StatusUnauthorized)
+			return
+		}
+		// Reissued tokens continue the session of the token they replace
+		authTime := time.Now()
+		if token, err := GetBearerToken(r.Request); err == nil {
+			if claims, err := VerifyToken(token); err == nil && claims.AuthTime != 0 {
+				authTime = time.Unix(claims.AuthTime, 0)
+			}
+		}
+		tokenString, err := newToken(user, authTime)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteJson(map[string]string{
+			"token": tokenString,
+		})
+	}
+}
+
 func ServeVerifyToken(db Database) func(rest.ResponseWriter, *rest.Request) {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		token, err := GetBearerToken(r.Request)
@@ -116,11 +282,88 @@ func ServeVerifyToken(db Database) func(rest.ResponseWriter, *rest.Request) {
 			rest.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
+		userId, err := strconv.ParseUint(claims.Subject, 10, 64)
+		if err != nil {
+			rest.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		if err := checkUserActive(db, userId); err != nil {
+			rest.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		w.WriteJson(claims)
 	}
 }
 
+// The profile fields returned to a user about themselves.
+type sessionUser struct {
+	Id        uint64    `json:"id"`
+	Username  string    `json:"username"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Verifies a token and returns the profile of its user, so clients can start
+// a session in one call. Tokens of deleted users are rejected.
+func ServeSession(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		token, err := GetBearerToken(r.Request)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		userId, err := AuthUserId(token)
+		if err != nil {
+			log.Printf("Error verifying token: %s", err.Error())
+			rest.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		user, err := db.GetUserById(userId)
+		if err == gorm.ErrRecordNotFound {
+			rest.Error(w, "User does not exist", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		SlideSession(w.Header(), r.Request)
+
+		w.WriteJson(sessionUser{
+			Id:        user.Id,
+			Username:  user.Username,
+			Admin:     user.Admin,
+			CreatedAt: user.CreatedAt,
+		})
+	}
+}
+
+// Verifies a token used to authenticate. Refresh tokens are rejected.
 func VerifyToken(tokenString string) (*DuetClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Refresh {
+		return nil, fmt.Errorf("Refresh tokens can't be used to authenticate")
+	}
+	return claims, nil
+}
+
+// Verifies a refresh token, rejecting tokens used to authenticate.
+func verifyRefreshToken(tokenString string) (*DuetClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Refresh {
+		return nil, fmt.Errorf("Not a refresh token")
+	}
+	return claims, nil
+}
+
+func parseToken(tokenString string) (*DuetClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &DuetClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/lib/pq"
+)
+
+func TestReissueTokenReflectsProfile(t *testing.T) {
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	token, err := newToken(&User{Id: 7, Username: "old"}, authTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The user has since been renamed and made an admin
+	db, _ := newFakeDatabase(t, systemClock{},
+		fakeResult{`FROM "users"`, []string{"id", "username", "admin"}, [][]driver.Value{{int64(7), "renamed", true}}})
+	w := serveRest(t, rest.Post("/reissue", ServeReissueToken(db)), httptest.NewRequest("POST", "/reissue", nil),
+		map[string]string{"Authorization": "Bearer " + token})
+	if w.Code != 200 {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyToken(body["token"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "7" || claims.Username != "renamed" || !claims.Admin {
+		t.Errorf("got claims %+v, want user 7 renamed and admin", claims)
+	}
+	if claims.AuthTime != authTime.Unix() {
+		t.Errorf("got auth time %d, want the original session's %d", claims.AuthTime, authTime.Unix())
+	}
+}
+
+func TestCreateUserDuplicateUsername(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`INSERT INTO "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}})
+	if _, err := db.CreateUser("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	// The unique index rejects the second user with the same username
+	conn.failOn(`INSERT INTO "users"`, &pq.Error{Code: uniqueViolation, Message: `duplicate key value violates unique constraint "users_username_key"`})
+	if _, err := db.CreateUser("alice", "secret"); err != ErrDuplicateUsername {
+		t.Errorf("got %v, want ErrDuplicateUsername", err)
+	}
+
+	// Other database errors aren't mistaken for duplicates
+	other := &pq.Error{Code: "23502", Message: "null value in column violates not-null constraint"}
+	conn.failOn(`INSERT INTO "users"`, other)
+	if _, err := db.CreateUser("bob", "secret"); err != other {
+		t.Errorf("got %v, want %v", err, other)
+	}
+}
+
+func TestServeCreateUserConflict(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{})
+	conn.failOn(`INSERT INTO "users"`, &pq.Error{Code: uniqueViolation, Message: `duplicate key value violates unique constraint "users_username_key"`})
+	r := httptest.NewRequest("POST", "/signup", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := serveRest(t, rest.Post("/signup", ServeCreateUser(db)), r, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want 409", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "duplicate key") {
+		t.Errorf("the database error reached the client: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// Returns whether a statement starting with prefix was sent with all of args.
+func sentWithArgs(conn *fakeConn, prefix string, args ...driver.Value) bool {
+	for _, statement := range conn.sent() {
+		if !strings.HasPrefix(statement.query, prefix) {
+			continue
+		}
+		found := true
+		for _, arg := range args {
+			if !hasArg(statement.args, arg) {
+				found = false
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMergeTasks(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	tests := []struct {
+		name  string
+		tasks [][]driver.Value
+		// Whether the tasks are merged
+		ok bool
+	}{
+		{"merged", [][]driver.Value{taskRow("keep", now), taskRow("merge", now)}, true},
+		{"not owned", [][]driver.Value{taskRow("keep", now)}, false},
+		{"different kinds", [][]driver.Value{taskRow("keep", now), habitRow("merge", Daily, 0)}, false},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, fixedClock(now),
+			fakeResult{"FROM reachable", []string{"count"}, [][]driver.Value{{int64(0)}}},
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(2)}}},
+			fakeResult{`FROM "tasks"`, taskColumns, test.tasks},
+			fakeResult{`FROM "actions"`, actionColumns, doneRows("keep", now, now)},
+		)
+		task, err := db.MergeTasks("keep", "merge", 1)
+
+		movedActions := sentWithArgs(conn, `UPDATE "actions" SET "task_id"`, "keep", "merge")
+		deleted := sentWithArgs(conn, `UPDATE "tasks" SET "deleted_at"`, now, "merge", int64(1))
+		if movedActions != test.ok || deleted != test.ok {
+			t.Errorf("%s: got actions moved %t and merged task deleted %t, want %t", test.name, movedActions, deleted, test.ok)
+		}
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, task)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		// The kept task is returned, which is the last one loaded
+		var lastLoaded fakeStatement
+		for _, statement := range conn.sent() {
+			if strings.HasPrefix(statement.query, `SELECT * FROM "tasks"`) {
+				lastLoaded = statement
+			}
+		}
+		if !hasArg(lastLoaded.args, "keep") || hasArg(lastLoaded.args, "merge") {
+			t.Errorf("%s: got %+v loaded by %v, want the kept task", test.name, task, lastLoaded)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Moves everything owned by the user with mergeId to the user with keepId and
+// deactivates the merged user, for people who signed up twice by accident.
+// Custom action kinds that both users have by the same name are combined.
+func (db gormDB) MergeUsers(keepId uint64, mergeId uint64) error {
+	if keepId == mergeId {
+		return fmt.Errorf("Can't merge a user with themselves")
+	}
+	tx := gormDB{DB: db.Begin(), clock: db.clock}
+	if err := tx.mergeUsers(keepId, mergeId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (tx gormDB) mergeUsers(keepId uint64, mergeId uint64) error {
+	for _, id := range []uint64{keepId, mergeId} {
+		if _, err := tx.GetUserById(id); err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+	}
+
+	// Deleted tasks and attachments move too so the kept user can restore them.
+	// Actions belong to their task, so they follow it.
+	if err := tx.Unscoped().Model(&Task{}).Where("user_id = ?", mergeId).UpdateColumn("user_id", keepId).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&Attachment{}).Where("user_id = ?", mergeId).UpdateColumn("user_id", keepId).Error; err != nil {
+		return err
+	}
+	if err := tx.mergeActionKinds(keepId, mergeId); err != nil {
+		return err
+	}
+	if err := tx.mergeSnapshots(keepId, mergeId); err != nil {
+		return err
+	}
+
+	return tx.Model(&User{}).Where("id = ?", mergeId).UpdateColumn("deleted_at", tx.Now()).Error
+}
+
+// Moves the merged user's custom action kinds to the kept user. Names are
+// unique per user, so a kind whose name the kept user already has is replaced
+// by the kept user's kind on its actions.
+func (tx gormDB) mergeActionKinds(keepId uint64, mergeId uint64) error {
+	var keptKinds []CustomActionKind
+	if err := tx.Unscoped().Where("user_id = ?", keepId).Find(&keptKinds).Error; err != nil {
+		return err
+	}
+	keptByName := make(map[string]uint64, len(keptKinds))
+	for _, kind := range keptKinds {
+		keptByName[kind.Name] = kind.Id
+	}
+
+	var mergedKinds []CustomActionKind
+	if err := tx.Unscoped().Where("user_id = ?", mergeId).Find(&mergedKinds).Error; err != nil {
+		return err
+	}
+	for _, kind := range mergedKinds {
+		keptId, ok := keptByName[kind.Name]
+		if !ok {
+			if err := tx.Unscoped().Model(&kind).UpdateColumn("user_id", keepId).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tx.Unscoped().Model(&Action{}).Where("custom_kind_id = ?", kind.Id).UpdateColumn("custom_kind_id", keptId).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&kind).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds the merged user's daily snapshots to the kept user's.
+func (tx gormDB) mergeSnapshots(keepId uint64, mergeId uint64) error {
+	err := tx.Exec(`UPDATE daily_snapshots kept SET completions = kept.completions + merged.completions
+		FROM daily_snapshots merged
+		WHERE kept.user_id = ? AND merged.user_id = ? AND kept.date = merged.date`, keepId, mergeId).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`DELETE FROM daily_snapshots merged
+		WHERE merged.user_id = ? AND EXISTS (
+			SELECT 1 FROM daily_snapshots kept WHERE kept.user_id = ? AND kept.date = merged.date
+		)`, mergeId, keepId).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&DailySnapshot{}).Where("user_id = ?", mergeId).UpdateColumn("user_id", keepId).Error
+}
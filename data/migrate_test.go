@@ -0,0 +1,93 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// Returns whether any statement sent changes the schema.
+func sentSchemaChange(conn *fakeConn) bool {
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, "CREATE") || strings.HasPrefix(statement.query, "ALTER") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrepareSchemaMigrates(t *testing.T) {
+	db, conn := openFake(t)
+	if err := prepareSchema(db, true); err != nil {
+		t.Fatal(err)
+	}
+	created := false
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, `CREATE TABLE "tasks"`) {
+			created = true
+		}
+	}
+	if !created {
+		t.Errorf("the missing tasks table wasn't created: %v", conn.sent())
+	}
+}
+
+func TestPrepareSchemaWithoutMigrating(t *testing.T) {
+	tests := []struct {
+		name string
+		// The count returned for each table and column looked up
+		exists int64
+		// Whether the schema is out of date
+		outOfDate bool
+	}{
+		{"up to date", 1, false},
+		{"missing tables", 0, true},
+	}
+	for _, test := range tests {
+		db, conn := openFake(t, fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{test.exists}}})
+		err := prepareSchema(db, false)
+		if test.outOfDate {
+			if err == nil || !strings.Contains(err.Error(), "DUET_AUTO_MIGRATE") || !strings.Contains(err.Error(), "tasks") {
+				t.Errorf("%s: got %v, want an error naming the missing tables", test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if sentSchemaChange(conn) {
+			t.Errorf("%s: the schema was changed without auto-migrate", test.name)
+		}
+	}
+}
+
+// A task model whose title field was renamed to name, so the tasks table
+// migrated for the old model has a title column it doesn't use.
+type renamedTask struct {
+	Id   string `gorm:"primary_key"`
+	Name string
+	Done bool
+}
+
+func (renamedTask) TableName() string {
+	return "tasks"
+}
+
+func TestExtraColumns(t *testing.T) {
+	defer func(saved []interface{}) { models = saved }(models)
+	models = []interface{}{&renamedTask{}}
+
+	// The columns of the table as migrated for the old model, then with name
+	// added by auto-migrating the new one
+	db, conn := openFake(t, fakeResult{"information_schema.columns", []string{"column_name", "required"},
+		[][]driver.Value{{"id", true}, {"title", true}, {"done", false}, {"note", false}, {"name", false}}})
+	got := extraColumns(db)
+	want := []string{
+		"tasks.title is not used by any model but is NOT NULL without a default, so inserts will fail",
+		"tasks.note is not used by any model",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings %q, want %q", got, want)
+	}
+	if statements := conn.sent(); len(statements) != 1 || !hasArg(statements[0].args, "tasks") {
+		t.Errorf("got queries %v, want the columns of tasks", statements)
+	}
+}
@@ -14,6 +14,8 @@ import (
 
 var todoistSyncUrl string = "https://todoist.com/API/v7/sync"
 
+const todoistDueDateLayout = "Mon 02 Jan 2006 15:04:05 +0000"
+
 var todoistConf *oauth2.Config = &oauth2.Config{
 	RedirectURL:  "https://api.helloduet.com/oauth/todoist/callback",
 	ClientID:     os.Getenv("TODOIST_ID"),
@@ -122,8 +124,7 @@ func SyncTodoist(db Database, userId uint64, oauthToken string) error {
 	for _, item := range sync.Items {
 		var endDate *time.Time
 		if item.DueDate != "" {
-			const longForm = "Mon 02 Jan 2006 15:04:05 +0000"
-			t, err := time.Parse(longForm, item.DueDate)
+			t, err := time.Parse(todoistDueDateLayout, item.DueDate)
 			if err != nil {
 				log.Printf("Error parsing due date '%s': '%s'", item.DueDate)
 			} else {
@@ -136,7 +137,7 @@ func SyncTodoist(db Database, userId uint64, oauthToken string) error {
 			Done:    item.Checked == 1,
 			EndDate: endDate,
 		}
-		err = db.AddTask(&task, userId)
+		_, err = db.AddTask(&task, userId)
 		if err != nil {
 			log.Printf("Error adding task: '%s'", err)
 		} else {
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/andyzg/duet/config"
+	"github.com/jinzhu/gorm"
+)
+
+// UserPreferences stores client settings such as theme and default view as a
+// JSON object so clients don't need storage of their own.
+type UserPreferences struct {
+	UserId      uint64    `json:"user_id" gorm:"primary_key"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Preferences string    `json:"preferences" gorm:"type:jsonb;not_null"`
+}
+
+// The largest preferences object that can be stored, in bytes.
+var maxPreferencesSize = config.Int("DUET_MAX_PREFERENCES_SIZE", 16*1024)
+
+// Returns the user's preferences as a JSON object.
+func (db gormDB) GetPreferences(userId uint64) (string, error) {
+	var prefs UserPreferences
+	err := db.Where("user_id = ?", userId).First(&prefs).Error
+	if err == gorm.ErrRecordNotFound {
+		return "{}", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return prefs.Preferences, nil
+}
+
+// Replaces the user's preferences with the given JSON object.
+func (db gormDB) UpdatePreferences(userId uint64, preferences string) error {
+	if len(preferences) > maxPreferencesSize {
+		return &ValidationError{"preferences", fmt.Sprintf("must be at most %d bytes", maxPreferencesSize)}
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(preferences), &object); err != nil || object == nil {
+		return &ValidationError{"preferences", "must be a JSON object"}
+	}
+
+	var prefs UserPreferences
+	return db.Where(UserPreferences{UserId: userId}).
+		Assign(UserPreferences{Preferences: preferences}).
+		FirstOrCreate(&prefs).Error
+}
@@ -0,0 +1,164 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jinzhu/gorm"
+)
+
+// GetPublicSchema returns the schema served to requests without credentials,
+// which can only log in or sign up to get a token.
+func GetPublicSchema(db Database) *graphql.Schema {
+	tokenPayload := graphql.NewObject(graphql.ObjectConfig{
+		Name: "tokenPayload",
+		Fields: graphql.Fields{
+			"token": &graphql.Field{
+				Type:        graphql.String,
+				Description: "A JWT to send as Authorization: Bearer <token>",
+			},
+			"refreshToken": &graphql.Field{
+				Type:        graphql.String,
+				Description: "A JWT to exchange for a new token with the refresh mutation once the token expires",
+			},
+			"user": &graphql.Field{
+				Type: graphql.NewObject(graphql.ObjectConfig{
+					Name: "PublicUser",
+					Fields: graphql.Fields{
+						"id": &graphql.Field{
+							Type: graphql.ID,
+						},
+						"username": &graphql.Field{
+							Type: graphql.String,
+						},
+					},
+				}),
+			},
+		},
+	})
+
+	credentialArgs := graphql.FieldConfigArgument{
+		"username": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"password": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	}
+
+	loginMutation := &graphql.Field{
+		Type: tokenPayload,
+		Args: credentialArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			username, _ := p.Args["username"].(string)
+			password, _ := p.Args["password"].(string)
+			user, err := authenticate(db, username, password)
+			if err != nil {
+				return nil, errInvalidCredentials
+			}
+			return sessionPayload(user, time.Now())
+		},
+		Description: "Logs in with a username and password",
+	}
+
+	signupMutation := &graphql.Field{
+		Type: tokenPayload,
+		Args: credentialArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			username, _ := p.Args["username"].(string)
+			password, _ := p.Args["password"].(string)
+			user, err := db.CreateUser(username, password)
+			if err == ErrDuplicateUsername {
+				return nil, &ValidationError{"username", "is already taken"}
+			}
+			if err != nil {
+				return nil, err
+			}
+			return sessionPayload(user, time.Now())
+		},
+		Description: "Creates a user and logs in as them",
+	}
+
+	refreshMutation := &graphql.Field{
+		Type: tokenPayload,
+		Args: graphql.FieldConfigArgument{
+			"refreshToken": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			refreshToken, _ := p.Args["refreshToken"].(string)
+			claims, err := verifyRefreshToken(refreshToken)
+			if err != nil {
+				return nil, errInvalidRefreshToken
+			}
+			userId, err := strconv.ParseUint(claims.Subject, 10, 64)
+			if err != nil {
+				return nil, errInvalidRefreshToken
+			}
+			user, err := db.GetUserById(userId)
+			if err == gorm.ErrRecordNotFound {
+				return nil, errUserDeactivated
+			}
+			if err != nil {
+				return nil, err
+			}
+			// The new tokens continue the session rather than starting one
+			return sessionPayload(user, time.Unix(claims.AuthTime, 0))
+		},
+		Description: "Exchanges a refresh token for a new token and refresh token",
+	}
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PublicQuery",
+		Fields: graphql.Fields{
+			"authenticated": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Always false, requests with credentials get the full schema",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return false, nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PublicMutation",
+		Fields: graphql.Fields{
+			"login":   loginMutation,
+			"signup":  signupMutation,
+			"refresh": refreshMutation,
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+	if err != nil {
+		panic(err)
+	}
+	recoverResolvers(&schema)
+	return &schema
+}
+
+var errInvalidRefreshToken = fmt.Errorf("Invalid refresh token")
+
+// Returns the tokens for a session of the user that started at authTime.
+func sessionPayload(user *User, authTime time.Time) (map[string]interface{}, error) {
+	token, err := newToken(user, authTime)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newRefreshToken(user, authTime)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         user,
+	}, nil
+}
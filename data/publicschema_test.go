@@ -0,0 +1,153 @@
+package data
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+// Returns a fake users result holding a user with the password.
+func userResult(t *testing.T, id uint64, username string, password string) fakeResult {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeResult{`FROM "users"`, []string{"id", "username", "hashed_password"},
+		[][]driver.Value{{int64(id), username, hashed}}}
+}
+
+func runPublicQuery(db Database, query string) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Schema:        *GetPublicSchema(db),
+		RequestString: query,
+		Context:       context.Background(),
+	})
+}
+
+// Checks that a payload's token authenticates as the user and that its
+// refresh token is one that can't authenticate.
+func checkTokenPayload(t *testing.T, name string, payload interface{}, userId uint64) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s: got payload %v", name, payload)
+	}
+	token, _ := fields["token"].(string)
+	if id, err := AuthUserId(token); err != nil || id != userId {
+		t.Errorf("%s: token authenticates as %d, %v, want %d", name, id, err, userId)
+	}
+	refreshToken, _ := fields["refreshToken"].(string)
+	if _, err := verifyRefreshToken(refreshToken); err != nil {
+		t.Errorf("%s: refresh token is invalid: %v", name, err)
+	}
+	if _, err := AuthUserId(refreshToken); err == nil {
+		t.Errorf("%s: refresh token was accepted to authenticate", name)
+	}
+}
+
+func TestLoginMutation(t *testing.T) {
+	db, _ := newFakeDatabase(t, systemClock{}, userResult(t, 7, "alice", "secret"))
+	result := runPublicQuery(db, `mutation { login(username: "alice", password: "secret") { token refreshToken user { id username } } }`)
+	if len(result.Errors) > 0 {
+		t.Fatalf("login failed: %v", result.Errors)
+	}
+	payload := result.Data.(map[string]interface{})["login"]
+	checkTokenPayload(t, "login", payload, 7)
+	user := payload.(map[string]interface{})["user"].(map[string]interface{})
+	if user["id"] != "7" || user["username"] != "alice" {
+		t.Errorf("got user %v, want 7 alice", user)
+	}
+}
+
+func TestLoginMutationWrongPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		results  []fakeResult
+	}{
+		{"wrong password", "alice", "guess", []fakeResult{userResult(t, 7, "alice", "secret")}},
+		{"unknown user", "bob", "secret", nil},
+	}
+	for _, test := range tests {
+		db, _ := newFakeDatabase(t, systemClock{}, test.results...)
+		result := runPublicQuery(db, `mutation { login(username: "`+test.username+`", password: "`+test.password+`") { token refreshToken } }`)
+		if len(result.Errors) != 1 || result.Errors[0].Message != errInvalidCredentials.Error() {
+			t.Errorf("%s: got errors %v, want %q", test.name, result.Errors, errInvalidCredentials)
+		}
+		if login := result.Data.(map[string]interface{})["login"]; login != nil {
+			t.Errorf("%s: got %v, want no tokens", test.name, login)
+		}
+	}
+}
+
+func TestSignupMutation(t *testing.T) {
+	db, _ := newFakeDatabase(t, systemClock{},
+		fakeResult{`INSERT INTO "users"`, []string{"id"}, [][]driver.Value{{int64(8)}}})
+	result := runPublicQuery(db, `mutation { signup(username: "carol", password: "secret") { token refreshToken user { id username } } }`)
+	if len(result.Errors) > 0 {
+		t.Fatalf("signup failed: %v", result.Errors)
+	}
+	checkTokenPayload(t, "signup", result.Data.(map[string]interface{})["signup"], 8)
+}
+
+func TestSignupMutationDuplicateUsername(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{})
+	conn.failOn(`INSERT INTO "users"`, &pq.Error{Code: uniqueViolation})
+	result := runPublicQuery(db, `mutation { signup(username: "alice", password: "secret") { token refreshToken } }`)
+	want := (&ValidationError{"username", "is already taken"}).Error()
+	if len(result.Errors) != 1 || result.Errors[0].Message != want {
+		t.Errorf("got errors %v, want %q", result.Errors, want)
+	}
+	if signup := result.Data.(map[string]interface{})["signup"]; signup != nil {
+		t.Errorf("got %v, want no tokens", signup)
+	}
+}
+
+func TestRefreshMutation(t *testing.T) {
+	user := &User{Id: 7, Username: "alice"}
+	payload, err := sessionPayload(user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"refresh token", payload["refreshToken"].(string), true},
+		{"access token", payload["token"].(string), false},
+		{"garbage", "not a token", false},
+	}
+	for _, test := range tests {
+		db, _ := newFakeDatabase(t, systemClock{}, userResult(t, 7, "alice", "secret"))
+		result := runPublicQuery(db, `mutation { refresh(refreshToken: "`+test.token+`") { token refreshToken } }`)
+		if !test.valid {
+			if len(result.Errors) != 1 || result.Errors[0].Message != errInvalidRefreshToken.Error() {
+				t.Errorf("%s: got errors %v, want %q", test.name, result.Errors, errInvalidRefreshToken)
+			}
+			continue
+		}
+		if len(result.Errors) > 0 {
+			t.Errorf("%s: refresh failed: %v", test.name, result.Errors)
+			continue
+		}
+		checkTokenPayload(t, test.name, result.Data.(map[string]interface{})["refresh"], 7)
+	}
+}
+
+func TestRefreshMutationDeactivatedUser(t *testing.T) {
+	payload, err := sessionPayload(&User{Id: 7, Username: "alice"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := newFakeDatabase(t, systemClock{})
+	result := runPublicQuery(db, `mutation { refresh(refreshToken: "`+payload["refreshToken"].(string)+`") { token } }`)
+	if len(result.Errors) != 1 || result.Errors[0].Message != errUserDeactivated.Error() {
+		t.Errorf("got errors %v, want %q", result.Errors, errUserDeactivated)
+	}
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Returned in place of a resolver's panic so that internal details never
+// reach clients. The code at the start of the message stays the same so
+// clients can recognize it.
+var errInternal = fmt.Errorf("INTERNAL_ERROR: An internal error occurred")
+
+// Wraps every resolver in the schema so that a panic is logged with its
+// stack trace and the field fails with errInternal. Fields with arguments
+// that failed to parse fail with the parse error, see rejectInvalidArgs.
+func recoverResolvers(schema *graphql.Schema) {
+	for name, t := range schema.TypeMap() {
+		object, ok := t.(*graphql.Object)
+		// Introspection resolvers belong to graphql
+		if !ok || strings.HasPrefix(name, "__") {
+			continue
+		}
+		// Objects rebuild their field definitions from their config, so the
+		// wrapped resolvers have to go back in as config
+		for fieldName, field := range object.Fields() {
+			if field.Resolve == nil {
+				continue
+			}
+			args := graphql.FieldConfigArgument{}
+			for _, arg := range field.Args {
+				args[arg.Name()] = &graphql.ArgumentConfig{
+					Type:         arg.Type,
+					DefaultValue: arg.DefaultValue,
+					Description:  arg.Description(),
+				}
+			}
+			object.AddFieldConfig(fieldName, &graphql.Field{
+				Name:              field.Name,
+				Type:              field.Type,
+				Args:              args,
+				Resolve:           recoveringResolver(object.Name()+"."+fieldName, rejectInvalidArgs(field.Resolve)),
+				DeprecationReason: field.DeprecationReason,
+				Description:       field.Description,
+			})
+		}
+	}
+}
+
+func recoveringResolver(name string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (result interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic resolving %s: %v\n%s", name, r, debug.Stack())
+				result = nil
+				err = errInternal
+			}
+		}()
+		return resolve(p)
+	}
+}
+
+// Scalars that fail to parse are replaced with a ValidationError describing
+// why, since graphql-go only reports a generic error for them. This fails the
+// field with the first such error in its arguments, including ones nested in
+// input objects and lists, before the resolver sees them.
+func rejectInvalidArgs(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if err := invalidArg(p.Args); err != nil {
+			return nil, err
+		}
+		return resolve(p)
+	}
+}
+
+func invalidArg(value interface{}) *ValidationError {
+	switch value := value.(type) {
+	case *ValidationError:
+		return value
+	case map[string]interface{}:
+		for _, field := range value {
+			if err := invalidArg(field); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := invalidArg(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+func TestRecoverResolvers(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"broken": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						var tasks map[string]*Task
+						return tasks["secret-task-id"].Title, nil
+					},
+				},
+				"working": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return "ok", nil
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoverResolvers(&schema)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: "{ broken working }",
+		Context:       context.Background(),
+	})
+
+	if len(result.Errors) != 1 || result.Errors[0].Message != errInternal.Error() {
+		t.Errorf("got errors %v, want only %q", result.Errors, errInternal)
+	}
+	data := result.Data.(map[string]interface{})
+	if data["broken"] != nil || data["working"] != "ok" {
+		t.Errorf("got %v, want only the working field", data)
+	}
+	if !strings.Contains(logged.String(), "Panic resolving Query.broken") || !strings.Contains(logged.String(), "recover_test.go") {
+		t.Errorf("the panic wasn't logged with its stack trace: %s", logged.String())
+	}
+
+	// The server keeps serving after a panic
+	result = graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: "{ working }",
+		Context:       context.Background(),
+	})
+	if len(result.Errors) > 0 || result.Data.(map[string]interface{})["working"] != "ok" {
+		t.Errorf("after a panic: got %v with errors %v", result.Data, result.Errors)
+	}
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrence is the subset of an iCalendar RRULE supported for tasks,
+// e.g. "FREQ=MONTHLY" or "FREQ=WEEKLY;INTERVAL=2".
+type recurrence struct {
+	freq     string
+	interval int
+}
+
+func parseRecurrenceRule(rule string) (*recurrence, error) {
+	r := &recurrence{interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, &ValidationError{"recurrence_rule", fmt.Sprintf("malformed part \"%s\"", part)}
+		}
+		switch key, value := strings.ToUpper(keyValue[0]), strings.ToUpper(keyValue[1]); key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return nil, &ValidationError{"recurrence_rule", fmt.Sprintf("unsupported FREQ \"%s\"", value)}
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return nil, &ValidationError{"recurrence_rule", "INTERVAL must be a positive integer"}
+			}
+			r.interval = interval
+		default:
+			return nil, &ValidationError{"recurrence_rule", fmt.Sprintf("unsupported part \"%s\"", key)}
+		}
+	}
+	if r.freq == "" {
+		return nil, &ValidationError{"recurrence_rule", "FREQ is required"}
+	}
+	return r, nil
+}
+
+func validateRecurrenceRule(rule string) error {
+	if rule == "" {
+		return nil
+	}
+	_, err := parseRecurrenceRule(rule)
+	return err
+}
+
+// Returns the time of the occurrence following t.
+func (r *recurrence) next(t time.Time) time.Time {
+	switch r.freq {
+	case "DAILY":
+		return t.AddDate(0, 0, r.interval)
+	case "WEEKLY":
+		return t.AddDate(0, 0, 7*r.interval)
+	case "MONTHLY":
+		return addMonths(t, r.interval)
+	default:
+		return addMonths(t, 12*r.interval)
+	}
+}
+
+// Adds months to t, clamping to the last day of the target month rather than
+// overflowing into the next one. Dates on the last day of a month stay on the
+// last day, so a task due on January 31st is next due on February 28th and
+// then March 31st.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	targetYear, targetMonth, _ := time.Date(year, month+time.Month(months), 1, 0, 0, 0, 0, time.UTC).Date()
+	targetDays := daysInMonth(targetYear, targetMonth)
+	if day > targetDays || day == daysInMonth(year, month) {
+		day = targetDays
+	}
+	hour, min, sec := t.Clock()
+	return time.Date(targetYear, targetMonth, day, hour, min, sec, t.Nanosecond(), t.Location())
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Returns a new, not yet created, task for the occurrence after task with its
+// dates advanced by the recurrence rule and the same title, color, icon,
+// pinning and reminder lead. Tasks without dates are due one period after
+// now.
+func nextOccurrence(task *Task, now time.Time) (*Task, error) {
+	r, err := parseRecurrenceRule(task.RecurrenceRule)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &Task{
+		Kind:           task.Kind,
+		Title:          task.Title,
+		RecurrenceRule: task.RecurrenceRule,
+		Pinned:         task.Pinned,
+		Color:          task.Color,
+		Icon:           task.Icon,
+		ReminderLead:   task.ReminderLead,
+	}
+	if task.StartDate != nil {
+		startDate := r.next(*task.StartDate)
+		next.StartDate = &startDate
+	}
+	if task.EndDate != nil {
+		endDate := r.next(*task.EndDate)
+		next.EndDate = &endDate
+	} else if task.StartDate == nil {
+		endDate := r.next(now)
+		next.EndDate = &endDate
+	}
+	return next, nil
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		freq     string
+		interval int
+		// A substring of the error, if the rule is rejected
+		err string
+	}{
+		{"FREQ=DAILY", "DAILY", 1, ""},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2", "WEEKLY", 2, ""},
+		{"freq=monthly", "MONTHLY", 1, ""},
+		{"INTERVAL=3;FREQ=YEARLY", "YEARLY", 3, ""},
+		{"", "", 0, "malformed part"},
+		{"FREQ", "", 0, "malformed part"},
+		{"FREQ=HOURLY", "", 0, "unsupported FREQ"},
+		{"FREQ=DAILY;INTERVAL=0", "", 0, "positive integer"},
+		{"FREQ=DAILY;INTERVAL=x", "", 0, "positive integer"},
+		{"FREQ=DAILY;BYDAY=MO", "", 0, "unsupported part"},
+		{"INTERVAL=2", "", 0, "FREQ is required"},
+	}
+	for _, test := range tests {
+		r, err := parseRecurrenceRule(test.rule)
+		if test.err != "" {
+			validationErr, ok := err.(*ValidationError)
+			if !ok || validationErr.Field != "recurrence_rule" || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got %v, want a recurrence_rule error containing %q", test.rule, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.rule, err)
+			continue
+		}
+		if r.freq != test.freq || r.interval != test.interval {
+			t.Errorf("%q: got %s every %d, want %s every %d", test.rule, r.freq, r.interval, test.freq, test.interval)
+		}
+	}
+}
+
+func TestValidateRecurrenceRule(t *testing.T) {
+	tests := []struct {
+		rule  string
+		valid bool
+	}{
+		{"", true},
+		{"FREQ=DAILY", true},
+		{"FREQ=SECONDLY", false},
+	}
+	for _, test := range tests {
+		if err := validateRecurrenceRule(test.rule); (err == nil) != test.valid {
+			t.Errorf("%q: got %v, want valid %t", test.rule, err, test.valid)
+		}
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	tests := []struct {
+		name      string
+		rule      string
+		startDate string
+		endDate   string
+		// The next occurrence's dates, or "" if it has none
+		nextStart string
+		nextEnd   string
+	}{
+		{"daily", "FREQ=DAILY", "", "2017-01-05T09:00:00Z", "", "2017-01-06T09:00:00Z"},
+		{"every other week", "FREQ=WEEKLY;INTERVAL=2", "2017-01-02T09:00:00Z", "2017-01-03T09:00:00Z", "2017-01-16T09:00:00Z", "2017-01-17T09:00:00Z"},
+		{"start date only", "FREQ=MONTHLY", "2017-01-15T09:00:00Z", "", "2017-02-15T09:00:00Z", ""},
+		{"monthly", "FREQ=MONTHLY", "", "2017-01-15T09:00:00Z", "", "2017-02-15T09:00:00Z"},
+		{"end of month", "FREQ=MONTHLY", "", "2017-01-31T09:00:00Z", "", "2017-02-28T09:00:00Z"},
+		{"back to the end of month", "FREQ=MONTHLY", "", "2017-02-28T09:00:00Z", "", "2017-03-31T09:00:00Z"},
+		{"clamped to a shorter month", "FREQ=MONTHLY", "", "2017-01-30T09:00:00Z", "", "2017-02-28T09:00:00Z"},
+		{"every other month across a year", "FREQ=MONTHLY;INTERVAL=2", "", "2016-12-31T09:00:00Z", "", "2017-02-28T09:00:00Z"},
+		{"yearly", "FREQ=YEARLY", "", "2016-03-10T09:00:00Z", "", "2017-03-10T09:00:00Z"},
+		{"leap day", "FREQ=YEARLY", "", "2016-02-29T09:00:00Z", "", "2017-02-28T09:00:00Z"},
+		{"no dates", "FREQ=WEEKLY", "", "", "", "2017-01-17T12:00:00Z"},
+	}
+	for _, test := range tests {
+		task := &Task{
+			Kind:           TaskEnum,
+			Title:          test.name,
+			RecurrenceRule: test.rule,
+			Pinned:         true,
+			Color:          "#1a2b3c",
+			Icon:           "money",
+			ReminderLead:   time.Hour,
+		}
+		if test.startDate != "" {
+			startDate := mustTime(t, test.startDate)
+			task.StartDate = &startDate
+		}
+		if test.endDate != "" {
+			endDate := mustTime(t, test.endDate)
+			task.EndDate = &endDate
+		}
+
+		next, err := nextOccurrence(task, now)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if next.Kind != task.Kind || next.Title != task.Title || next.RecurrenceRule != task.RecurrenceRule ||
+			next.Pinned != task.Pinned || next.Color != task.Color || next.Icon != task.Icon || next.ReminderLead != task.ReminderLead {
+			t.Errorf("%s: got %+v, want a copy of %+v", test.name, next, task)
+		}
+		checkOccurrenceDate(t, test.name, "start date", next.StartDate, test.nextStart)
+		checkOccurrenceDate(t, test.name, "end date", next.EndDate, test.nextEnd)
+	}
+
+	if _, err := nextOccurrence(&Task{RecurrenceRule: "FREQ=HOURLY"}, now); err == nil {
+		t.Error("an invalid rule had a next occurrence")
+	}
+}
+
+func checkOccurrenceDate(t *testing.T, name string, field string, got *time.Time, want string) {
+	if want == "" {
+		if got != nil {
+			t.Errorf("%s: got a %s of %s, want none", name, field, got)
+		}
+		return
+	}
+	if got == nil || !got.Equal(mustTime(t, want)) {
+		t.Errorf("%s: got a %s of %v, want %s", name, field, got, want)
+	}
+}
+
+func TestCompletingRecurringTaskCreatesNextOccurrence(t *testing.T) {
+	endDate := mustTime(t, "2017-01-31T09:00:00Z")
+	db, conn := newFakeDatabase(t, fixedClock(mustTime(t, "2017-01-30T12:00:00Z")),
+		fakeResult{`INSERT INTO "tasks"`, []string{"id"}, [][]driver.Value{{"next"}}},
+		fakeResult{`INSERT INTO "actions"`, []string{"id"}, [][]driver.Value{{"done"}}},
+		fakeResult{`FROM "tasks"`, []string{"id", "kind", "title", "user_id", "end_date", "recurrence_rule"},
+			[][]driver.Value{{"rent", int64(TaskEnum), "Pay rent", int64(1), endDate, "FREQ=MONTHLY"}}},
+	)
+	count, err := db.SetTasksDone([]string{"rent"}, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d tasks done, want 1", count)
+	}
+
+	want := mustTime(t, "2017-02-28T09:00:00Z")
+	created := false
+	for _, statement := range conn.sent() {
+		if !strings.Contains(statement.query, `INSERT INTO "tasks"`) {
+			continue
+		}
+		created = true
+		if !hasTimeArg(statement.args, want) || !hasArg(statement.args, "FREQ=MONTHLY") {
+			t.Errorf("created %v, want a monthly task due %s", statement.args, want)
+		}
+	}
+	if !created {
+		t.Error("the next occurrence wasn't created")
+	}
+}
+
+func hasArg(args []driver.Value, want driver.Value) bool {
+	for _, arg := range args {
+		if arg == want {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTimeArg(args []driver.Value, want time.Time) bool {
+	for _, arg := range args {
+		if when, ok := arg.(time.Time); ok && when.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestReadsUseReplica(t *testing.T) {
+	habit := HabitEnum
+	tests := []struct {
+		name string
+		run  func(db Database) error
+		// Whether every statement goes to the replica rather than the primary
+		replica bool
+	}{
+		{"get task", func(db Database) error {
+			_, err := db.GetTask("t", 1, nil)
+			return err
+		}, true},
+		{"get tasks", func(db Database) error {
+			_, err := db.GetTasks(1, &habit, TaskListOptions{})
+			return err
+		}, true},
+		{"stats", func(db Database) error {
+			_, err := db.CountCompletedTasks(1)
+			return err
+		}, true},
+		{"add task", func(db Database) error {
+			_, err := db.AddTask(&Task{Kind: TaskEnum, Title: "Title"}, 1)
+			return err
+		}, false},
+		{"update task", func(db Database) error {
+			_, err := db.UpdateTask("t", 1, map[string]interface{}{"title": "Title"})
+			return err
+		}, false},
+		{"delete task", func(db Database) error {
+			_, err := db.DeleteTask("t", 1)
+			return err
+		}, false},
+		{"restart habit", func(db Database) error {
+			_, err := db.RestartHabit("t", 1)
+			return err
+		}, false},
+		{"sync changes", func(db Database) error {
+			_, err := db.GetTasksModifiedSince(1, mustTime(t, "2017-01-10T12:00:00Z"))
+			return err
+		}, false},
+	}
+	results := []fakeResult{
+		{`INSERT INTO "tasks"`, []string{"id"}, [][]driver.Value{{"t"}}},
+		{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+		{`FROM "tasks"`, taskColumns, [][]driver.Value{habitRow("t", Daily, 0)}},
+	}
+	for _, test := range tests {
+		primary, primaryConn := openFake(t, results...)
+		replica, replicaConn := openFake(t, results...)
+		if err := test.run(NewDatabase(primary, replica, systemClock{})); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		sentToPrimary, sentToReplica := len(primaryConn.sent()), len(replicaConn.sent())
+		if test.replica && (sentToPrimary > 0 || sentToReplica == 0) {
+			t.Errorf("%s: sent %d statements to the primary and %d to the replica, want only the replica",
+				test.name, sentToPrimary, sentToReplica)
+		}
+		if !test.replica && (sentToPrimary == 0 || sentToReplica > 0) {
+			t.Errorf("%s: sent %d statements to the primary and %d to the replica, want only the primary",
+				test.name, sentToPrimary, sentToReplica)
+		}
+
+		// Without a replica everything goes to the primary
+		primary, primaryConn = openFake(t, results...)
+		if err := test.run(NewDatabase(primary, nil, systemClock{})); err != nil {
+			t.Errorf("%s without a replica: %s", test.name, err)
+		} else if len(primaryConn.sent()) == 0 {
+			t.Errorf("%s without a replica: nothing was sent to the primary", test.name)
+		}
+	}
+}
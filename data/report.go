@@ -0,0 +1,177 @@
+package data
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HabitReport summarizes one habit over a report's date range.
+type HabitReport struct {
+	Title       string
+	Interval    string
+	Frequency   int
+	Streak      int
+	Completions int
+	Expected    int
+	// The share of expected completions that were made, at most 1
+	Rate float64
+}
+
+var intervalNames = map[Interval]string{
+	Daily:   "Daily",
+	Weekly:  "Weekly",
+	Monthly: "Monthly",
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(rate float64) int { return int(rate*100 + 0.5) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duet progress report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.4em 0.8em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Progress report for {{.Username}}</h1>
+<p id="period">{{.From.Format "January 2, 2006"}} to {{.To.Format "January 2, 2006"}}</p>
+<h2>Habits</h2>
+{{if .Habits}}
+<table id="habits">
+<tr><th>Habit</th><th>Goal</th><th>Current streak</th><th>Completions</th><th>Completion rate</th></tr>
+{{range .Habits}}
+<tr><td>{{.Title}}</td><td>{{.Frequency}} &times; {{.Interval}}</td><td>{{.Streak}}</td><td>{{.Completions}} of {{.Expected}}</td><td>{{percent .Rate}}%</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No habits yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// Summarizes each of the habits between from (inclusive) and to (exclusive).
+// Streaks are as of now.
+func habitReports(habits []Task, from time.Time, to time.Time, now time.Time) []HabitReport {
+	reports := make([]HabitReport, 0, len(habits))
+	for i := range habits {
+		habit := &habits[i]
+		periods := 0
+		for start := periodStart(habit.Interval, from); start.Before(to); start = nextPeriod(habit.Interval, start) {
+			periods++
+		}
+
+		// Restarts only reset the streak, the report covers all completions
+		completions := 0
+		for _, action := range habit.Actions {
+			if action.Kind == ActionDone && action.When != nil && !action.When.Before(from) && action.When.Before(to) {
+				completions++
+			}
+		}
+
+		report := HabitReport{
+			Title:       habit.Title,
+			Interval:    intervalNames[habit.Interval],
+			Frequency:   habit.Frequency,
+			Streak:      habitStreak(habit, now),
+			Completions: completions,
+			Expected:    periods * habit.Frequency,
+		}
+		if report.Expected > 0 {
+			report.Rate = float64(completions) / float64(report.Expected)
+			if report.Rate > 1 {
+				report.Rate = 1
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Returns the start of the period after the one starting at start.
+func nextPeriod(interval Interval, start time.Time) time.Time {
+	switch interval {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// How far back reports go when no start date is given.
+const defaultReportDays = 30
+
+// HandleReport serves a printable HTML report of the user's habits. The
+// range is given by the from and to query parameters as YYYY-MM-DD dates,
+// both inclusive, and defaults to the last 30 days.
+func HandleReport(db Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, err := AuthRequest(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		if format := query.Get("format"); format != "" && format != "html" {
+			http.Error(w, "Unsupported report format, only html is available", http.StatusBadRequest)
+			return
+		}
+
+		now := db.Now()
+		today := periodStart(Daily, now)
+		to := today.AddDate(0, 0, 1)
+		if value := query.Get("to"); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			to = date.AddDate(0, 0, 1)
+		}
+		from := to.AddDate(0, 0, -defaultReportDays)
+		if value := query.Get("from"); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			from = date
+		}
+		if !from.Before(to) {
+			http.Error(w, "The from date must not be after the to date", http.StatusBadRequest)
+			return
+		}
+
+		user, err := db.GetUserById(userId)
+		if err != nil {
+			http.Error(w, "User does not exist", http.StatusUnauthorized)
+			return
+		}
+		kind := HabitEnum
+		habits, err := db.GetTasks(userId, &kind, TaskListOptions{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = reportTemplate.Execute(w, map[string]interface{}{
+			"Username": user.Username,
+			"From":     from,
+			"To":       to.AddDate(0, 0, -1),
+			"Habits":   habitReports(habits, from, to, now),
+		})
+		if err != nil {
+			log.Printf("Rendering report for user %d failed: %s", userId, err.Error())
+		}
+	})
+}
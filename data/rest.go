@@ -0,0 +1,162 @@
+package data
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// Returns the ID of the user authenticated by the request, writing a 401 and
+// returning false if authentication fails.
+func restUserId(db Database, w rest.ResponseWriter, r *rest.Request) (uint64, bool) {
+	userId, err := AuthRequest(db, r.Request)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusUnauthorized)
+		return 0, false
+	}
+	SlideSession(w.Header(), r.Request)
+	return userId, true
+}
+
+// Like restUserId but also writes a 403 and returns false if the user isn't
+// an admin.
+func restAdminId(db Database, w rest.ResponseWriter, r *rest.Request) (uint64, bool) {
+	userId, ok := restUserId(db, w, r)
+	if !ok {
+		return 0, false
+	}
+	user, err := db.GetUserById(userId)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !user.Admin {
+		rest.Error(w, "Admin access required", http.StatusForbidden)
+		return 0, false
+	}
+	return userId, true
+}
+
+// Lists the user's tasks and habits. Responses carry an ETag so clients
+// polling for changes get a 304 when nothing changed.
+func ServeGetTasks(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := restUserId(db, w, r)
+		if !ok {
+			return
+		}
+
+		known := strings.Trim(r.Header.Get("If-None-Match"), `"`)
+		tasks, version, err := db.GetTasksIfChanged(userId, known)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"`+version+`"`)
+		if tasks == nil {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteJson(tasks)
+	}
+}
+
+// Deactivates the authenticated user's account.
+func ServeDeactivate(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := restUserId(db, w, r)
+		if !ok {
+			return
+		}
+		if err := db.DeactivateUser(userId); err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Reactivates the account with the ID in the path. Only admins may do this.
+func ServeReactivate(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if _, ok := restAdminId(db, w, r); !ok {
+			return
+		}
+		id, err := strconv.ParseUint(r.PathParam("id"), 10, 64)
+		if err != nil {
+			rest.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		err = db.ReactivateUser(id)
+		if err == ErrNotFound {
+			rest.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type mergeUserRequest struct {
+	MergeId uint64 `json:"merge_id"`
+}
+
+// Merges the user with merge_id in the body into the user with the ID in the
+// path. Only admins may do this.
+func ServeMergeUsers(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if _, ok := restAdminId(db, w, r); !ok {
+			return
+		}
+		keepId, err := strconv.ParseUint(r.PathParam("id"), 10, 64)
+		if err != nil {
+			rest.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		var req mergeUserRequest
+		if err := r.DecodeJsonPayload(&req); err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.MergeId == 0 || req.MergeId == keepId {
+			rest.Error(w, "merge_id must be another user's ID", http.StatusBadRequest)
+			return
+		}
+
+		err = db.MergeUsers(keepId, req.MergeId)
+		if err == ErrNotFound {
+			rest.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ServeGetAction(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := restUserId(db, w, r)
+		if !ok {
+			return
+		}
+
+		action, err := db.GetAction(r.PathParam("id"), userId)
+		if err == ErrNotFound {
+			rest.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteJson(action)
+	}
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// Serves a request through a router with only the route, setting the
+// headers on it first.
+func serveRest(t *testing.T, route *rest.Route, r *http.Request, headers map[string]string) *httptest.ResponseRecorder {
+	router, err := rest.MakeRouter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := rest.NewApi()
+	api.SetApp(router)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	api.MakeHandler().ServeHTTP(w, r)
+	return w
+}
+
+// Returns an Authorization header with a token of the user.
+func bearer(t *testing.T, user *User) map[string]string {
+	token, err := newToken(user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// Serves a request for the task list of user 1, whose tasks were last
+// modified at lastModified.
+func serveTaskList(t *testing.T, lastModified time.Time, ifNoneMatch string) *httptest.ResponseRecorder {
+	db, _ := newFakeDatabase(t, systemClock{},
+		fakeResult{"last_modified", []string{"last_modified", "count"}, [][]driver.Value{{lastModified, int64(1)}}},
+		fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("t", lastModified)}},
+		fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}},
+	)
+	headers := bearer(t, &User{Id: 1})
+	if ifNoneMatch != "" {
+		headers["If-None-Match"] = ifNoneMatch
+	}
+	return serveRest(t, rest.Get("/tasks", ServeGetTasks(db)), httptest.NewRequest("GET", "/tasks", nil), headers)
+}
+
+func TestTaskListETag(t *testing.T) {
+	lastModified := mustTime(t, "2017-01-10T12:00:00Z")
+	first := serveTaskList(t, lastModified, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("got status %d with ETag %q and body %q, want the tasks with an ETag", first.Code, etag, first.Body.String())
+	}
+
+	unchanged := serveTaskList(t, lastModified, etag)
+	if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() > 0 {
+		t.Errorf("unchanged: got status %d with body %q, want 304", unchanged.Code, unchanged.Body.String())
+	}
+	if got := unchanged.Header().Get("ETag"); got != etag {
+		t.Errorf("unchanged: got ETag %q, want %q", got, etag)
+	}
+
+	modified := serveTaskList(t, lastModified.Add(time.Second), etag)
+	if modified.Code != http.StatusOK || modified.Body.Len() == 0 {
+		t.Errorf("modified: got status %d with body %q, want the tasks", modified.Code, modified.Body.String())
+	}
+	if got := modified.Header().Get("ETag"); got == "" || got == etag {
+		t.Errorf("modified: got ETag %q, want a new one", got)
+	}
+}
+
+func TestDeactivatedUserTokenRejected(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	active := fakeResult{`FROM "users"`, []string{"id", "username"}, [][]driver.Value{{int64(7), "alice"}}}
+	db, conn := newFakeDatabase(t, fixedClock(now), active)
+	token := bearer(t, &User{Id: 7, Username: "alice"})
+	verify := func() *httptest.ResponseRecorder {
+		return serveRest(t, rest.Get("/verify", ServeVerifyToken(db)), httptest.NewRequest("GET", "/verify", nil), token)
+	}
+
+	if w := verify(); w.Code != http.StatusOK {
+		t.Fatalf("active: got status %d, want 200", w.Code)
+	}
+
+	w := serveRest(t, rest.Post("/deactivate", ServeDeactivate(db)), httptest.NewRequest("POST", "/deactivate", nil), token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("deactivating: got status %d: %s", w.Code, w.Body.String())
+	}
+	if !sentWithArgs(conn, `UPDATE "users" SET "deleted_at"`, now, int64(7)) {
+		t.Fatalf("the user wasn't soft deleted: %v", conn.sent())
+	}
+	// Lookups of users skip soft deleted ones, so the user is no longer found
+	conn.setResults()
+	if w := verify(); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), errUserDeactivated.Error()) {
+		t.Errorf("deactivated: got status %d with %s, want 401", w.Code, w.Body.String())
+	}
+	if _, err := Login(db, "alice", "secret"); err == nil {
+		t.Error("deactivated: logged in")
+	}
+
+	if err := db.ReactivateUser(7); err != nil {
+		t.Fatal(err)
+	}
+	if !sentWithArgs(conn, `UPDATE "users" SET "deleted_at"`, nil, int64(7)) {
+		t.Fatalf("the user wasn't restored: %v", conn.sent())
+	}
+	conn.setResults(active)
+	if w := verify(); w.Code != http.StatusOK {
+		t.Errorf("reactivated: got status %d, want 200", w.Code)
+	}
+}
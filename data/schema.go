@@ -1,11 +1,15 @@
 package data
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/language/ast"
+	"github.com/jinzhu/gorm"
 )
 
 const UserIdKey string = "user_id"
@@ -15,44 +19,253 @@ func userIdOfContext(p graphql.ResolveParams) uint64 {
 	return id
 }
 
+// Converts a list argument to a slice of strings.
+func stringsOfArg(arg interface{}) []string {
+	values, _ := arg.([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+// Returns the task being resolved, which is a Task when it's part of a list.
+func taskOfSource(source interface{}) *Task {
+	switch task := source.(type) {
+	case *Task:
+		return task
+	case Task:
+		return &task
+	}
+	return nil
+}
+
+// The source of the stats fields. The task and habit counts come from one
+// query, loaded by whichever of the two is resolved first.
+type statsSource struct {
+	once       sync.Once
+	countKinds func() (map[TaskKind]int64, error)
+	kindCounts map[TaskKind]int64
+	kindErr    error
+}
+
+func (s *statsSource) countsByKind() (map[TaskKind]int64, error) {
+	s.once.Do(func() {
+		s.kindCounts, s.kindErr = s.countKinds()
+	})
+	return s.kindCounts, s.kindErr
+}
+
+var errAdminRequired = fmt.Errorf("Admin access required")
+
+// Returns an error unless the authenticated user is an admin.
+func requireAdmin(db Database, p graphql.ResolveParams) error {
+	user, err := db.GetUserById(userIdOfContext(p))
+	if err != nil {
+		return err
+	}
+	if !user.Admin {
+		return errAdminRequired
+	}
+	return nil
+}
+
+// The most tasks addTasks creates at once.
+const maxBatchTasks = 100
+
+// The most actions importActions adds at once.
+const maxImportActions = 500
+
+// How many actions the task query loads by default and at most.
+const defaultRecentActions = 20
+const maxRecentActions = 100
+
+// The most actions allActions returns at once.
+const maxActionsPage = 200
+
+// The most results recentlyCompleted returns at once.
+const maxRecentlyCompleted = 100
+
+// Loads the time zone in the timezone argument, defaulting to UTC.
+func locationOfArg(p graphql.ResolveParams) (*time.Location, error) {
+	timezone, ok := p.Args["timezone"].(string)
+	if !ok {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, &ValidationError{"timezone", "is not a known time zone"}
+	}
+	return loc, nil
+}
+
+// Splits tasks by kind into a "tasks" and "habits" object.
+func tasksAndHabits(all []Task) map[string]interface{} {
+	tasks := []Task{}
+	habits := []Task{}
+	for _, task := range all {
+		if task.Kind == HabitEnum {
+			habits = append(habits, task)
+		} else {
+			tasks = append(tasks, task)
+		}
+	}
+	return map[string]interface{}{
+		"tasks":  tasks,
+		"habits": habits,
+	}
+}
+
+// Builds the tasks described by TaskInput arguments.
+func tasksOfInputs(inputs []interface{}) []*Task {
+	tasks := make([]*Task, 0, len(inputs))
+	for _, input := range inputs {
+		fields, _ := input.(map[string]interface{})
+		id, _ := fields["id"].(string)
+		title, _ := fields["title"].(string)
+		startDate, _ := fields["start_date"].(*time.Time)
+		endDate, _ := fields["end_date"].(*time.Time)
+		recurrenceRule, _ := fields["recurrence_rule"].(string)
+		color, _ := fields["color"].(string)
+		icon, _ := fields["icon"].(string)
+		done, _ := fields["done"].(bool)
+		tasks = append(tasks, &Task{
+			Id:             id,
+			Title:          title,
+			StartDate:      startDate,
+			EndDate:        endDate,
+			RecurrenceRule: recurrenceRule,
+			Color:          color,
+			Icon:           icon,
+			Done:           done,
+			Kind:           TaskEnum,
+		})
+	}
+	return tasks
+}
+
+func taskErrorsOutput(taskErrs []TaskError) []map[string]interface{} {
+	output := make([]map[string]interface{}, 0, len(taskErrs))
+	for _, taskErr := range taskErrs {
+		output = append(output, map[string]interface{}{
+			"index":   taskErr.Index,
+			"field":   taskErr.Field,
+			"message": taskErr.Message,
+		})
+	}
+	return output
+}
+
+// Parses an RFC 3339 date and time. graphql-go can only report a generic
+// "invalid value" for scalars that fail to parse, so malformed input parses to
+// a ValidationError instead, which rejectInvalidArgs returns before the
+// field's resolver runs.
+func parseDateTime(value interface{}) interface{} {
+	if str, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339, str); err == nil {
+			return &t
+		}
+	}
+	return &ValidationError{"DateTime", fmt.Sprintf("\"%v\" must be an RFC 3339 date and time like 2017-01-10T12:00:00Z", value)}
+}
+
+// Converts a literal in a query to the equivalent Go value.
+func valueOfAST(valueAST ast.Value) interface{} {
+	switch valueAST := valueAST.(type) {
+	case *ast.ObjectValue:
+		object := make(map[string]interface{})
+		for _, field := range valueAST.Fields {
+			object[field.Name.Value] = valueOfAST(field.Value)
+		}
+		return object
+	case *ast.ListValue:
+		list := make([]interface{}, len(valueAST.Values))
+		for i, value := range valueAST.Values {
+			list[i] = valueOfAST(value)
+		}
+		return list
+	case *ast.IntValue:
+		if i, err := strconv.ParseInt(valueAST.Value, 10, 64); err == nil {
+			return i
+		}
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(valueAST.Value, 64); err == nil {
+			return f
+		}
+	case *ast.StringValue:
+		return valueAST.Value
+	case *ast.BooleanValue:
+		return valueAST.Value
+	case *ast.EnumValue:
+		return valueAST.Value
+	}
+	return nil
+}
+
+func taskListOptionsOfArgs(args map[string]interface{}) TaskListOptions {
+	pinnedOnly, _ := args["pinned"].(bool)
+	pinnedFirst, _ := args["pinnedFirst"].(bool)
+	includeArchived, _ := args["includeArchived"].(bool)
+	return TaskListOptions{
+		PinnedOnly:      pinnedOnly,
+		PinnedFirst:     pinnedFirst,
+		IncludeArchived: includeArchived,
+	}
+}
+
 func GetSchema(db Database) *graphql.Schema {
-	dateType := graphql.NewScalar(graphql.ScalarConfig{
-		Name:        "Date",
-		Description: "Date and time",
+	dateTimeType := graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "DateTime",
+		Description: "Date and time as an RFC 3339 string",
 		Serialize: func(t interface{}) interface{} {
 			switch t := t.(type) {
 			case *time.Time:
 				if t != nil {
-					return t.Unix()
+					return t.UTC().Format(time.RFC3339)
 				}
 			case time.Time:
-				return t.Unix()
+				return t.UTC().Format(time.RFC3339)
 			}
 			return nil
 		},
-		ParseValue: func(unix interface{}) interface{} {
-			// Variables are parsed by graphql-go-hander and passed as a map
-			// It parses ints as a float64 but we keep int64 for completeness
-			switch unix := unix.(type) {
-			case int64:
-				t := time.Unix(unix, 0)
-				return &t
-			case float64:
-				t := time.Unix(int64(unix), 0)
-				return &t
+		ParseValue: func(value interface{}) interface{} {
+			return parseDateTime(value)
+		},
+		ParseLiteral: func(valueAST ast.Value) interface{} {
+			return parseDateTime(valueOfAST(valueAST))
+		},
+	})
+
+	// JSON values are passed to and from the data layer as encoded strings
+	jsonType := graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "JSON",
+		Description: "An arbitrary JSON value",
+		Serialize: func(value interface{}) interface{} {
+			str, ok := value.(string)
+			if !ok {
+				return nil
 			}
-			return nil
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(str), &decoded); err != nil {
+				return nil
+			}
+			return decoded
+		},
+		ParseValue: func(value interface{}) interface{} {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil
+			}
+			return string(encoded)
 		},
 		ParseLiteral: func(valueAST ast.Value) interface{} {
-			// This is when the value is part of the query
-			switch valueAST := valueAST.(type) {
-			case *ast.IntValue:
-				if intValue, err := strconv.ParseInt(valueAST.Value, 10, 32); err == nil {
-					t := time.Unix(intValue, 0)
-					return &t
-				}
+			encoded, err := json.Marshal(valueOfAST(valueAST))
+			if err != nil {
+				return nil
 			}
-			return nil
+			return string(encoded)
 		},
 	})
 
@@ -62,16 +275,20 @@ func GetSchema(db Database) *graphql.Schema {
 		Values: graphql.EnumValueConfigMap{
 			"PROGRESS": &graphql.EnumValueConfig{
 				Value:       ActionProgress,
-				Description: "Indication of progress on the task",
+				Description: "Progress on the task, counted by progressCount until the task is next done",
 			},
 			"DEFER": &graphql.EnumValueConfig{
 				Value:       ActionDefer,
-				Description: "User is defering the task",
+				Description: "User is postponing the task without completing it",
 			},
 			"DONE": &graphql.EnumValueConfig{
 				Value:       ActionDone,
 				Description: "User has completed the task",
 			},
+			"CUSTOM": &graphql.EnumValueConfig{
+				Value:       ActionCustom,
+				Description: "A kind defined by the user, given by customKindId",
+			},
 		},
 	})
 
@@ -105,11 +322,83 @@ func GetSchema(db Database) *graphql.Schema {
 				Type: actionKind,
 			},
 			"when": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
+			},
+			"note": &graphql.Field{
+				Type: graphql.String,
+			},
+			"custom_kind_id": &graphql.Field{
+				Type: graphql.ID,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var customKindId *uint64
+					switch action := p.Source.(type) {
+					case *Action:
+						customKindId = action.CustomKindId
+					case Action:
+						customKindId = action.CustomKindId
+					}
+					if customKindId == nil {
+						return nil, nil
+					}
+					return *customKindId, nil
+				},
+			},
+		},
+	})
+
+	customActionKindType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "CustomActionKind",
+		Description: "A kind of action defined by the user",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"created_at": &graphql.Field{
+				Type: dateTimeType,
+			},
+		},
+	})
+
+	attachmentType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Attachment",
+		Description: "A URL attached to a task or habit",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"task_id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"url": &graphql.Field{
+				Type: graphql.String,
+			},
+			"label": &graphql.Field{
+				Type: graphql.String,
+			},
+			"created_at": &graphql.Field{
+				Type: dateTimeType,
 			},
 		},
 	})
 
+	// Shared by tasks and habits, both of which can have progress actions.
+	// Counted in the database since tasks may only have some of their actions
+	// loaded.
+	progressCountField := &graphql.Field{
+		Type:        graphql.Int,
+		Description: "The number of PROGRESS actions since the last DONE action",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			task := taskOfSource(p.Source)
+			if task == nil {
+				return nil, nil
+			}
+			return db.CountProgressSinceDone(task.Id, userIdOfContext(p))
+		},
+	}
+
 	taskType := graphql.NewObject(graphql.ObjectConfig{
 		Name:        "Task",
 		Description: "A TODO task",
@@ -121,22 +410,89 @@ func GetSchema(db Database) *graphql.Schema {
 				Type: graphql.String,
 			},
 			"start_date": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
 			},
 			"end_date": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
+			},
+			"recurrence_rule": &graphql.Field{
+				Type:        graphql.String,
+				Description: "How the task repeats as an RRULE, e.g. FREQ=MONTHLY",
 			},
 			"done": &graphql.Field{
 				Type: graphql.Boolean,
 			},
+			"pinned": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"archived_at": &graphql.Field{
+				Type: dateTimeType,
+			},
+			"color": &graphql.Field{
+				Type: graphql.String,
+			},
+			"icon": &graphql.Field{
+				Type: graphql.String,
+			},
+			"progressCount": progressCountField,
+			"blocked": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether any task this one depends on isn't done yet",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					task := taskOfSource(p.Source)
+					if task == nil {
+						return nil, nil
+					}
+					return db.IsTaskBlocked(task.Id, userIdOfContext(p))
+				},
+			},
+			"dependsOn": &graphql.Field{
+				Type:        graphql.NewList(graphql.ID),
+				Description: "The IDs of the tasks that must be done before this one",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					task := taskOfSource(p.Source)
+					if task == nil {
+						return nil, nil
+					}
+					return db.GetDependencyIds(task.Id, userIdOfContext(p))
+				},
+			},
 			"actions": &graphql.Field{
 				Type: graphql.NewList(actionType),
 			},
 			"created_at": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
 			},
 			"updated_at": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
+			},
+		},
+	})
+
+	calendarDayType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "CalendarDay",
+		Description: "Whether a habit was completed on a day",
+		Fields: graphql.Fields{
+			"day": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The day of the month",
+			},
+			"completed": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+		},
+	})
+
+	bucketStatType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "BucketStat",
+		Description: "How many times a habit was completed in one of its periods",
+		Fields: graphql.Fields{
+			"start": &graphql.Field{
+				Type:        dateTimeType,
+				Description: "When the period started, in UTC",
+			},
+			"completions": &graphql.Field{
+				Type: graphql.Int,
 			},
 		},
 	})
@@ -158,16 +514,209 @@ func GetSchema(db Database) *graphql.Schema {
 				Type: graphql.Int,
 			},
 			"done": &graphql.Field{
+				Type:              graphql.Boolean,
+				DeprecationReason: "Habits recur, so a single done flag doesn't track them. Use the DONE actions in `actions` instead.",
+			},
+			"pinned": &graphql.Field{
 				Type: graphql.Boolean,
 			},
+			"color": &graphql.Field{
+				Type: graphql.String,
+			},
+			"icon": &graphql.Field{
+				Type: graphql.String,
+			},
+			"streak": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The number of consecutive periods the habit has been met, counting from its last restart",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					return habitStreak(habit, db.Now()), nil
+				},
+			},
+			"longestStreak": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The most consecutive periods the habit has ever been met, including before restarts",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					return habitLongestStreak(habit), nil
+				},
+			},
+			"restarted_at": &graphql.Field{
+				Type: dateTimeType,
+			},
+			"reminderLead": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "How many minutes before the end of each period to remind the user if the habit isn't met yet, or 0 for no reminders",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					return int(habit.ReminderLead / time.Minute), nil
+				},
+			},
+			"nextReminder": &graphql.Field{
+				Type:        dateTimeType,
+				Description: "When to next remind the user, skipping the current period once the habit is met",
+				Args: graphql.FieldConfigArgument{
+					"timezone": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The IANA time zone that days start in, e.g. America/Toronto. Defaults to UTC",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					loc, err := locationOfArg(p)
+					if err != nil {
+						return nil, err
+					}
+					return habitNextReminder(habit, db.Now(), loc), nil
+				},
+			},
+			"metThisPeriod": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the habit has been completed frequency times in the current period",
+				Args: graphql.FieldConfigArgument{
+					"timezone": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The IANA time zone that days start in, e.g. America/Toronto. Defaults to UTC",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					loc, err := locationOfArg(p)
+					if err != nil {
+						return nil, err
+					}
+					since := habitCurrentPeriodStart(habit, db.Now(), loc)
+					count, err := db.CountCompletionsSince(habit.Id, userIdOfContext(p), since)
+					if err != nil {
+						return nil, err
+					}
+					return count >= int64(habit.Frequency), nil
+				},
+			},
+			"calendar": &graphql.Field{
+				Type:        graphql.NewList(calendarDayType),
+				Description: "Whether the habit was completed on each day of a month",
+				Args: graphql.FieldConfigArgument{
+					"year": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"month": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.Int),
+						Description: "The month from 1 for January to 12 for December",
+					},
+					"timezone": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The IANA time zone that days start in, e.g. America/Toronto. Defaults to UTC",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					month, _ := p.Args["month"].(int)
+					if month < 1 || month > 12 {
+						return nil, &ValidationError{"month", "must be between 1 and 12"}
+					}
+					loc, err := locationOfArg(p)
+					if err != nil {
+						return nil, err
+					}
+					year, _ := p.Args["year"].(int)
+					calendar, err := db.GetHabitCalendar(habit.Id, userIdOfContext(p), year, time.Month(month), loc)
+					if err != nil {
+						return nil, err
+					}
+					days := make([]map[string]interface{}, 0, len(calendar))
+					for day := 1; day <= len(calendar); day++ {
+						days = append(days, map[string]interface{}{
+							"day":       day,
+							"completed": calendar[day],
+						})
+					}
+					return days, nil
+				},
+			},
+			"trend": &graphql.Field{
+				Type:        graphql.NewList(bucketStatType),
+				Description: "How many times the habit was completed in each of its last periods, oldest first, for drawing a sparkline",
+				Args: graphql.FieldConfigArgument{
+					"buckets": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: 12,
+						Description:  "How many periods to cover, including the current one",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					buckets, _ := p.Args["buckets"].(int)
+					return db.GetHabitTrend(habit.Id, userIdOfContext(p), buckets)
+				},
+			},
+			"hourHistogram": &graphql.Field{
+				Type:        graphql.NewList(graphql.Int),
+				Description: "How many times the habit was completed in each hour of the day, from midnight to 11pm, for suggesting a reminder time",
+				Args: graphql.FieldConfigArgument{
+					"timezone": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "The IANA time zone of the hours, e.g. America/Toronto. Defaults to UTC",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					loc, err := locationOfArg(p)
+					if err != nil {
+						return nil, err
+					}
+					histogram, err := db.GetHabitHourHistogram(habit.Id, userIdOfContext(p), loc)
+					if err != nil {
+						return nil, err
+					}
+					return histogram[:], nil
+				},
+			},
+			"nextDue": &graphql.Field{
+				Type:        dateTimeType,
+				Description: "When the habit is next expected to be completed",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					habit := taskOfSource(p.Source)
+					if habit == nil {
+						return nil, nil
+					}
+					return habitNextDue(habit), nil
+				},
+			},
+			"progressCount": progressCountField,
 			"actions": &graphql.Field{
 				Type: graphql.NewList(actionType),
 			},
 			"created_at": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
 			},
 			"updated_at": &graphql.Field{
-				Type: dateType,
+				Type: dateTimeType,
 			},
 		},
 	})
@@ -185,6 +734,120 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 	})
 
+	timelineActionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TimelineAction",
+		Description: "An action along with the title of its task",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"kind": &graphql.Field{
+				Type: actionKind,
+			},
+			"when": &graphql.Field{
+				Type: dateTimeType,
+			},
+			"task_id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"task_title": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	timelineEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TimelineEntry",
+		Description: "The actions performed on one day",
+		Fields: graphql.Fields{
+			"date": &graphql.Field{
+				Type: dateTimeType,
+			},
+			"actions": &graphql.Field{
+				Type: graphql.NewList(timelineActionType),
+			},
+		},
+	})
+
+	// Each figure is resolved separately so that one failing leaves it null
+	// with an error rather than losing all of them
+	userStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "UserStats",
+		Description: "Totals across a user's tasks and habits",
+		Fields: graphql.Fields{
+			"tasks": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					counts, err := p.Source.(*statsSource).countsByKind()
+					if err != nil {
+						return nil, err
+					}
+					return counts[TaskEnum], nil
+				},
+			},
+			"habits": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					counts, err := p.Source.(*statsSource).countsByKind()
+					if err != nil {
+						return nil, err
+					}
+					return counts[HabitEnum], nil
+				},
+			},
+			"completed_tasks": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					count, err := db.CountCompletedTasks(userIdOfContext(p))
+					if err != nil {
+						return nil, err
+					}
+					return count, nil
+				},
+			},
+			"longest_streak": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The longest current streak across all habits",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					streak, err := db.GetLongestCurrentStreak(userIdOfContext(p))
+					if err != nil {
+						return nil, err
+					}
+					return streak, nil
+				},
+			},
+			"actions_this_week": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					count, err := db.CountActionsSince(userIdOfContext(p), periodStart(Weekly, db.Now()))
+					if err != nil {
+						return nil, err
+					}
+					return count, nil
+				},
+			},
+		},
+	})
+
+	apiKeyType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ApiKey",
+		Description: "A long-lived key for authenticating integrations",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"label": &graphql.Field{
+				Type: graphql.String,
+			},
+			"created_at": &graphql.Field{
+				Type: dateTimeType,
+			},
+			"last_used_at": &graphql.Field{
+				Type: dateTimeType,
+			},
+		},
+	})
+
 	userQuery := &graphql.Field{
 		Type: userType,
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
@@ -204,14 +867,25 @@ func GetSchema(db Database) *graphql.Schema {
 		Type: taskType,
 		Args: graphql.FieldConfigArgument{
 			"id": &graphql.ArgumentConfig{
-				Type:         graphql.ID,
-				DefaultValue: nil,
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"recentActions": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: defaultRecentActions,
+				Description:  "How many of the task's most recent actions to load, newest first, up to 100",
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id := p.Args["id"].(string)
+			id, _ := p.Args["id"].(string)
+			recentActions, _ := p.Args["recentActions"].(int)
+			if recentActions < 1 || recentActions > maxRecentActions {
+				return nil, &ValidationError{"recentActions", fmt.Sprintf("must be between 1 and %d", maxRecentActions)}
+			}
 			kind := TaskEnum
-			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			task, err := db.GetTaskWithRecentActions(id, userIdOfContext(p), &kind, recentActions)
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrNotFound
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -227,14 +901,16 @@ func GetSchema(db Database) *graphql.Schema {
 		Type: habitType,
 		Args: graphql.FieldConfigArgument{
 			"id": &graphql.ArgumentConfig{
-				Type:         graphql.ID,
-				DefaultValue: nil,
+				Type: graphql.NewNonNull(graphql.ID),
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id := p.Args["id"].(string)
+			id, _ := p.Args["id"].(string)
 			kind := HabitEnum
 			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrNotFound
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -246,111 +922,1198 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 	}
 
-	tasksQuery := &graphql.Field{
-		Type: graphql.NewList(taskType),
+	actionQuery := &graphql.Field{
+		Type: actionType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			kind := TaskEnum
-			return db.GetTasks(userIdOfContext(p), &kind)
+			id, _ := p.Args["id"].(string)
+			action, err := db.GetAction(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return action, nil
 		},
 	}
 
-	habitsQuery := &graphql.Field{
-		Type: graphql.NewList(habitType),
+	timelineQuery := &graphql.Field{
+		Type: graphql.NewList(timelineEntryType),
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			kind := HabitEnum
-			return db.GetTasks(userIdOfContext(p), &kind)
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("from and to must be valid dates")
+			}
+			return db.GetActionTimeline(userIdOfContext(p), *from, *to)
 		},
+		Description: "Actions on all tasks and habits between from and to, grouped by day",
 	}
 
-	addTaskMutation := &graphql.Field{
-		Type: taskType,
+	allActionsQuery := &graphql.Field{
+		Type: graphql.NewList(timelineActionType),
 		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type: graphql.ID,
+			"kind": &graphql.ArgumentConfig{
+				Type: actionKind,
 			},
-			"title": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(graphql.String),
+			"from": &graphql.ArgumentConfig{
+				Type:        dateTimeType,
+				Description: "Only actions at or after this time",
 			},
-			"start_date": &graphql.ArgumentConfig{
-				Type: dateType,
+			"to": &graphql.ArgumentConfig{
+				Type:        dateTimeType,
+				Description: "Only actions before this time",
 			},
-			"end_date": &graphql.ArgumentConfig{
-				Type: dateType,
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 50,
+				Description:  "The most actions to return, up to 200",
 			},
-			"done": &graphql.ArgumentConfig{
-				Type: graphql.Boolean,
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+				Description:  "How many of the most recent actions to skip",
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id, _ := p.Args["id"].(string)
+			filter := ActionFilter{}
+			if kind, ok := p.Args["kind"].(ActionKind); ok {
+				filter.Kind = &kind
+			}
+			filter.From, _ = p.Args["from"].(*time.Time)
+			filter.To, _ = p.Args["to"].(*time.Time)
+			filter.Limit, _ = p.Args["limit"].(int)
+			if filter.Limit < 1 || filter.Limit > maxActionsPage {
+				return nil, &ValidationError{"limit", fmt.Sprintf("must be between 1 and %d", maxActionsPage)}
+			}
+			filter.Offset, _ = p.Args["offset"].(int)
+			if filter.Offset < 0 {
+				return nil, &ValidationError{"offset", "can't be negative"}
+			}
+			return db.GetAllActions(userIdOfContext(p), filter)
+		},
+		Description: "Actions on all tasks and habits with their titles, most recent first",
+	}
+
+	activityQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "Activity",
+			Description: "The tasks and habits with actions in a time window",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type: graphql.NewList(taskType),
+				},
+				"habits": &graphql.Field{
+					Type: graphql.NewList(habitType),
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"kind": &graphql.ArgumentConfig{
+				Type:        actionKind,
+				Description: "Only count actions of this kind",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("from and to must be valid dates")
+			}
+			var kind *ActionKind
+			if k, ok := p.Args["kind"].(ActionKind); ok {
+				kind = &k
+			}
+			tasks, err := db.GetTasksWithActivity(userIdOfContext(p), *from, *to, kind)
+			if err != nil {
+				return nil, err
+			}
+			return tasksAndHabits(tasks), nil
+		},
+		Description: "Tasks and habits with actions between from and to",
+	}
+
+	snapshotsQuery := &graphql.Field{
+		Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name:        "DailySnapshot",
+			Description: "The number of completions recorded on a UTC day, as of the end of that day",
+			Fields: graphql.Fields{
+				"date": &graphql.Field{
+					Type: dateTimeType,
+				},
+				"completions": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		})),
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("from and to must be valid dates")
+			}
+			return db.GetSnapshots(userIdOfContext(p), *from, *to)
+		},
+		Description: "Daily completion counts for the days from from to to. Days without completions are left out",
+	}
+
+	previewOccurrencesQuery := &graphql.Field{
+		Type: graphql.NewList(dateTimeType),
+		Args: graphql.FieldConfigArgument{
+			"interval": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(interval),
+			},
+			"frequency": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			interval, _ := p.Args["interval"].(Interval)
+			frequency, _ := p.Args["frequency"].(int)
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("from and to must be valid dates")
+			}
+			return PreviewOccurrences(interval, frequency, *from, *to)
+		},
+		Description: "When a habit with the interval and frequency would be due between from and to, without creating it",
+	}
+
+	signupStatsQuery := &graphql.Field{
+		Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name:        "DailyCount",
+			Description: "The number of events on a UTC day",
+			Fields: graphql.Fields{
+				"date": &graphql.Field{
+					Type: dateTimeType,
+				},
+				"count": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		})),
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if err := requireAdmin(db, p); err != nil {
+				return nil, err
+			}
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			if from == nil || to == nil {
+				return nil, fmt.Errorf("from and to must be valid dates")
+			}
+			return db.GetSignupStats(*from, *to)
+		},
+		Description: "Signups per day between from and to. Only available to admins",
+	}
+
+	statsQuery := &graphql.Field{
+		Type: userStatsType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			userId := userIdOfContext(p)
+			return &statsSource{
+				countKinds: func() (map[TaskKind]int64, error) {
+					return db.CountTasksByKind(userId)
+				},
+			}, nil
+		},
+	}
+
+	quotaQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "Quota",
+			Description: "How many tasks and habits the user has against the most they may have",
+			Fields: graphql.Fields{
+				"used": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"limit": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The most tasks and habits the user may have, or 0 if there's no limit",
+				},
+			},
+		}),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			used, limit, err := db.GetQuotaStatus(userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"used":  used,
+				"limit": limit,
+			}, nil
+		},
+	}
+
+	preferencesQuery := &graphql.Field{
+		Type: jsonType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return db.GetPreferences(userIdOfContext(p))
+		},
+		Description: "Client settings stored for the user",
+	}
+
+	taskCountsQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "TaskCounts",
+			Description: "The number of tasks and habits a user has",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"habits": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		}),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			counts, err := db.CountTasksByKind(userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"tasks":  counts[TaskEnum],
+				"habits": counts[HabitEnum],
+			}, nil
+		},
+	}
+
+	pendingCountQuery := &graphql.Field{
+		Type: graphql.Int,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return db.CountPendingTasks(userIdOfContext(p))
+		},
+		Description: "Number of tasks not yet done plus habits not yet met this period",
+	}
+
+	changesQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "Changes",
+			Description: "Tasks and habits changed since a point in time, for syncing clients",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type:        graphql.NewList(taskType),
+					Description: "Tasks created or updated since then",
+				},
+				"habits": &graphql.Field{
+					Type:        graphql.NewList(habitType),
+					Description: "Habits created or updated since then",
+				},
+				"deletedIds": &graphql.Field{
+					Type:        graphql.NewList(graphql.ID),
+					Description: "IDs of tasks and habits deleted since then",
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"since": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			since, _ := p.Args["since"].(*time.Time)
+			changed, err := db.GetTasksModifiedSince(userIdOfContext(p), *since)
+			if err != nil {
+				return nil, err
+			}
+			tasks := []Task{}
+			habits := []Task{}
+			deletedIds := []string{}
+			for _, task := range changed {
+				switch {
+				case task.DeletedAt != nil:
+					deletedIds = append(deletedIds, task.Id)
+				case task.Kind == HabitEnum:
+					habits = append(habits, task)
+				default:
+					tasks = append(tasks, task)
+				}
+			}
+			return map[string]interface{}{
+				"tasks":      tasks,
+				"habits":     habits,
+				"deletedIds": deletedIds,
+			}, nil
+		},
+	}
+
+	todayQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "TodayView",
+			Description: "What needs doing today",
+			Fields: graphql.Fields{
+				"due_today": &graphql.Field{
+					Type: graphql.NewList(taskType),
+				},
+				"overdue": &graphql.Field{
+					Type:        graphql.NewList(taskType),
+					Description: "Tasks that were due before today",
+				},
+				"habits": &graphql.Field{
+					Type:        graphql.NewList(habitType),
+					Description: "Habits not yet met in their current period",
+				},
+				"completed_today": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"timezone": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "The IANA time zone that days start in, e.g. America/Toronto. Defaults to UTC",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			loc, err := locationOfArg(p)
+			if err != nil {
+				return nil, err
+			}
+			return db.GetTodayView(userIdOfContext(p), loc, db.Now())
+		},
+	}
+
+	tasksByIdsQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "TasksByIds",
+			Description: "The tasks and habits found for a list of IDs",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type: graphql.NewList(taskType),
+				},
+				"habits": &graphql.Field{
+					Type: graphql.NewList(habitType),
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"ids": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			tasks, err := db.GetTasksByIds(stringsOfArg(p.Args["ids"]), userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return tasksAndHabits(tasks), nil
+		},
+		Description: "Fetches several tasks and habits at once, skipping IDs that aren't found",
+	}
+
+	overdueQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "Overdue",
+			Description: "Tasks past their end date and habits behind for the current period",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type: graphql.NewList(taskType),
+				},
+				"habits": &graphql.Field{
+					Type: graphql.NewList(habitType),
+				},
+			},
+		}),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			overdue, err := db.GetOverdueTasks(userIdOfContext(p), db.Now())
+			if err != nil {
+				return nil, err
+			}
+			return tasksAndHabits(overdue), nil
+		},
+	}
+
+	recentlyCompletedQuery := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name:        "RecentlyCompleted",
+			Description: "Tasks and habits ordered by when they were last marked done, most recent first",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type: graphql.NewList(taskType),
+				},
+				"habits": &graphql.Field{
+					Type: graphql.NewList(habitType),
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 10,
+				Description:  "The most tasks and habits to return in total, up to 100",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			limit, _ := p.Args["limit"].(int)
+			if limit < 1 || limit > maxRecentlyCompleted {
+				return nil, &ValidationError{"limit", fmt.Sprintf("must be between 1 and %d", maxRecentlyCompleted)}
+			}
+			tasks, err := db.GetRecentlyCompleted(userIdOfContext(p), limit)
+			if err != nil {
+				return nil, err
+			}
+			return tasksAndHabits(tasks), nil
+		},
+	}
+
+	apiKeysQuery := &graphql.Field{
+		Type: graphql.NewList(apiKeyType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return db.ListApiKeys(userIdOfContext(p))
+		},
+	}
+
+	actionKindsQuery := &graphql.Field{
+		Type: graphql.NewList(customActionKindType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return db.ListActionKinds(userIdOfContext(p))
+		},
+	}
+
+	attachmentsQuery := &graphql.Field{
+		Type: graphql.NewList(attachmentType),
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			return db.ListAttachments(taskId, userIdOfContext(p))
+		},
+	}
+
+	taskListArgs := graphql.FieldConfigArgument{
+		"pinned": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Only return pinned items",
+		},
+		"pinnedFirst": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Sort pinned items before the rest",
+		},
+		"includeArchived": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Include archived tasks",
+		},
+	}
+
+	archiveCompletedMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "archiveCompletedPayload",
+			Fields: graphql.Fields{
+				"archived": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The number of tasks archived",
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"olderThanDays": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+				Description:  "Only archive tasks completed at least this many days ago",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			days, _ := p.Args["olderThanDays"].(int)
+			if days < 0 {
+				return nil, &ValidationError{"olderThanDays", "can't be negative"}
+			}
+			archived, err := db.ArchiveCompletedTasks(userIdOfContext(p), time.Duration(days)*24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"archived": archived}, nil
+		},
+		Description: "Archives done tasks so they no longer appear in task lists",
+	}
+
+	tasksQuery := &graphql.Field{
+		Type: graphql.NewList(taskType),
+		Args: taskListArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			kind := TaskEnum
+			return db.GetTasks(userIdOfContext(p), &kind, taskListOptionsOfArgs(p.Args))
+		},
+	}
+
+	habitsQuery := &graphql.Field{
+		Type: graphql.NewList(habitType),
+		Args: taskListArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			kind := HabitEnum
+			return db.GetTasks(userIdOfContext(p), &kind, taskListOptionsOfArgs(p.Args))
+		},
+	}
+
+	addTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "A UUID chosen by the client so the create can be retried. Retrying returns the task already created",
+			},
+			"title": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"start_date": &graphql.ArgumentConfig{
+				Type: dateTimeType,
+			},
+			"end_date": &graphql.ArgumentConfig{
+				Type: dateTimeType,
+			},
+			"recurrence_rule": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"icon": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			title, _ := p.Args["title"].(string)
+			startDate, _ := p.Args["start_date"].(*time.Time)
+			endDate, _ := p.Args["end_date"].(*time.Time)
+			recurrenceRule, _ := p.Args["recurrence_rule"].(string)
+			color, _ := p.Args["color"].(string)
+			icon, _ := p.Args["icon"].(string)
+			done, _ := p.Args["done"].(bool)
+
+			newTask := &Task{
+				Id:             id,
+				Title:          title,
+				StartDate:      startDate,
+				EndDate:        endDate,
+				RecurrenceRule: recurrenceRule,
+				Color:          color,
+				Icon:           icon,
+				Done:           done,
+				Kind:           TaskEnum,
+			}
+
+			task, err := db.AddTask(newTask, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return task, nil
+		},
+	}
+
+	taskInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "TaskInput",
+		Description: "A task to create",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"title": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"start_date": &graphql.InputObjectFieldConfig{
+				Type: dateTimeType,
+			},
+			"end_date": &graphql.InputObjectFieldConfig{
+				Type: dateTimeType,
+			},
+			"recurrence_rule": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"color": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"icon": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"done": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+		},
+	})
+
+	taskInputErrorType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TaskInputError",
+		Description: "Why a task in a batch was rejected",
+		Fields: graphql.Fields{
+			"index": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The position of the task in the batch",
+			},
+			"field": &graphql.Field{
+				Type: graphql.String,
+			},
+			"message": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	addTasksMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "addTasksPayload",
+			Fields: graphql.Fields{
+				"tasks": &graphql.Field{
+					Type:        graphql.NewList(taskType),
+					Description: "The tasks that were created",
+				},
+				"errors": &graphql.Field{
+					Type: graphql.NewList(taskInputErrorType),
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"tasks": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskInputType))),
+			},
+			"skipInvalid": &graphql.ArgumentConfig{
+				Type:         graphql.Boolean,
+				DefaultValue: false,
+				Description:  "Create the valid tasks even if some are invalid. Otherwise no tasks are created unless all are valid",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["tasks"].([]interface{})
+			if len(inputs) > maxBatchTasks {
+				return nil, &ValidationError{"tasks", fmt.Sprintf("must contain at most %d tasks", maxBatchTasks)}
+			}
+
+			skipInvalid, _ := p.Args["skipInvalid"].(bool)
+			created, invalid, err := db.AddTasks(tasksOfInputs(inputs), userIdOfContext(p), skipInvalid)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"tasks":  created,
+				"errors": taskErrorsOutput(invalid),
+			}, nil
+		},
+		Description: "Creates several tasks at once, reporting every invalid task rather than only the first",
+	}
+
+	validateTasksQuery := &graphql.Field{
+		Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name:        "TaskValidation",
+			Description: "Whether a task in a batch would be accepted by addTasks",
+			Fields: graphql.Fields{
+				"index": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The position of the task in the batch",
+				},
+				"valid": &graphql.Field{
+					Type: graphql.Boolean,
+				},
+				"errors": &graphql.Field{
+					Type:        graphql.NewList(taskInputErrorType),
+					Description: "Why the task would be rejected, empty if it's valid",
+				},
+			},
+		})),
+		Args: graphql.FieldConfigArgument{
+			"tasks": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskInputType))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["tasks"].([]interface{})
+			if len(inputs) > maxBatchTasks {
+				return nil, &ValidationError{"tasks", fmt.Sprintf("must contain at most %d tasks", maxBatchTasks)}
+			}
+			invalid, err := ValidateTasks(tasksOfInputs(inputs))
+			if err != nil {
+				return nil, err
+			}
+			errorsByIndex := make(map[int][]TaskError)
+			for _, taskErr := range invalid {
+				errorsByIndex[taskErr.Index] = append(errorsByIndex[taskErr.Index], taskErr)
+			}
+			results := make([]map[string]interface{}, 0, len(inputs))
+			for i := range inputs {
+				results = append(results, map[string]interface{}{
+					"index":  i,
+					"valid":  len(errorsByIndex[i]) == 0,
+					"errors": taskErrorsOutput(errorsByIndex[i]),
+				})
+			}
+			return results, nil
+		},
+		Description: "Checks a batch of tasks the way addTasks would without creating them, so forms can report errors before submitting",
+	}
+
+	addHabitMutation := &graphql.Field{
+		Type: habitType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "A UUID chosen by the client so the create can be retried. Retrying returns the habit already created",
+			},
+			"title": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"interval": &graphql.ArgumentConfig{
+				Type:        interval,
+				Description: "Defaults to the server's configured default interval",
+			},
+			"frequency": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Defaults to the server's configured default frequency",
+			},
+			"reminderLead": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "How many minutes before the end of each period to remind the user, or 0 for no reminders",
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"icon": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
 			title, _ := p.Args["title"].(string)
-			startDate, _ := p.Args["start_date"].(*time.Time)
-			endDate, _ := p.Args["end_date"].(*time.Time)
+			interval, ok := p.Args["interval"].(Interval)
+			if !ok {
+				interval = defaultHabitInterval
+			}
+			frequency, ok := p.Args["frequency"].(int)
+			if !ok {
+				frequency = defaultHabitFrequency
+			}
+			reminderLead, _ := p.Args["reminderLead"].(int)
+			color, _ := p.Args["color"].(string)
+			icon, _ := p.Args["icon"].(string)
 			done, _ := p.Args["done"].(bool)
 
 			newTask := &Task{
-				Id:        id,
-				Title:     title,
-				StartDate: startDate,
-				EndDate:   endDate,
-				Done:      done,
-				Kind:      TaskEnum,
+				Id:           id,
+				Title:        title,
+				Interval:     interval,
+				Frequency:    frequency,
+				ReminderLead: time.Duration(reminderLead) * time.Minute,
+				Color:        color,
+				Icon:         icon,
+				Done:         done,
+				Kind:         HabitEnum,
+			}
+
+			task, err := db.AddTask(newTask, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return task, nil
+		},
+	}
+
+	deleteTaskMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "removeTaskPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			taskDeleted, err := db.DeleteTask(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !taskDeleted {
+				return nil, nil
+			}
+			return id, nil
+		},
+		Description: "Deletes a task or habit by ID",
+	}
+
+	restoreTaskMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "restoreTaskPayload",
+			Fields: graphql.Fields{
+				"restoredId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			taskRestored, err := db.RestoreTask(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !taskRestored {
+				return nil, nil
+			}
+			return id, nil
+		},
+		Description: "Restores a deleted task or habit and its actions by ID",
+	}
+
+	updateTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.ID,
+			},
+			"title": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"start_date": &graphql.ArgumentConfig{
+				Type: dateTimeType,
+			},
+			"end_date": &graphql.ArgumentConfig{
+				Type: dateTimeType,
+			},
+			"recurrence_rule": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"icon": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+
+			attrs := make(map[string]interface{})
+
+			if title, ok := p.Args["title"].(string); ok {
+				attrs["title"] = title
+			}
+			if startDate, ok := p.Args["start_date"].(*time.Time); ok {
+				attrs["start_date"] = startDate
+			}
+			if endDate, ok := p.Args["end_date"].(*time.Time); ok {
+				attrs["end_date"] = endDate
+			}
+			if recurrenceRule, ok := p.Args["recurrence_rule"].(string); ok {
+				attrs["recurrence_rule"] = recurrenceRule
+			}
+			if color, ok := p.Args["color"].(string); ok {
+				attrs["color"] = color
+			}
+			if icon, ok := p.Args["icon"].(string); ok {
+				attrs["icon"] = icon
+			}
+			if done, ok := p.Args["done"].(bool); ok {
+				attrs["done"] = done
+			}
+
+			return db.UpdateTask(id, userIdOfContext(p), attrs)
+		},
+	}
+
+	updateHabitMutation := &graphql.Field{
+		Type: habitType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.ID,
+			},
+			"title": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"interval": &graphql.ArgumentConfig{
+				Type: interval,
+			},
+			"frequency": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"reminderLead": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "How many minutes before the end of each period to remind the user, or 0 for no reminders",
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"icon": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+
+			attrs := make(map[string]interface{})
+
+			if title, ok := p.Args["title"].(string); ok {
+				attrs["title"] = title
+			}
+			if interval, ok := p.Args["interval"].(Interval); ok {
+				attrs["interval"] = interval
+			}
+			if frequency, ok := p.Args["frequency"].(int); ok {
+				attrs["frequency"] = frequency
+			}
+			if lead, ok := p.Args["reminderLead"].(int); ok {
+				attrs["reminder_lead"] = time.Duration(lead) * time.Minute
+			}
+			if color, ok := p.Args["color"].(string); ok {
+				attrs["color"] = color
+			}
+			if icon, ok := p.Args["icon"].(string); ok {
+				attrs["icon"] = icon
+			}
+			if done, ok := p.Args["done"].(bool); ok {
+				attrs["done"] = done
+			}
+
+			return db.UpdateTask(id, userIdOfContext(p), attrs)
+		},
+	}
+
+	restartHabitMutation := &graphql.Field{
+		Type: habitType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			return db.RestartHabit(id, userIdOfContext(p))
+		},
+		Description: "Restarts a habit's streak while keeping its history",
+	}
+
+	mergeTasksMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"keepId": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "The task that is kept",
+			},
+			"mergeId": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "The task whose actions are moved to the kept task before it's deleted",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			keepId, _ := p.Args["keepId"].(string)
+			mergeId, _ := p.Args["mergeId"].(string)
+			task, err := db.MergeTasks(keepId, mergeId, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return task, nil
+		},
+		Description: "Merges a duplicate task into another of the same kind, moving its actions, attachments and dependencies",
+	}
+
+	setTasksDoneMutation := &graphql.Field{
+		Type: graphql.Int,
+		Args: graphql.FieldConfigArgument{
+			"ids": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))),
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ids := stringsOfArg(p.Args["ids"])
+			done, _ := p.Args["done"].(bool)
+			return db.SetTasksDone(ids, userIdOfContext(p), done)
+		},
+		Description: "Sets the done state of several tasks and returns how many changed. Habits are skipped",
+	}
+
+	pinTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			return db.PinTask(id, userIdOfContext(p))
+		},
+		Description: "Pins a task or habit",
+	}
+
+	unpinTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			return db.UnpinTask(id, userIdOfContext(p))
+		},
+		Description: "Unpins a task or habit",
+	}
+
+	transferTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"username": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Username of the user receiving the task",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			username, _ := p.Args["username"].(string)
+
+			recipient, err := db.GetUserByUsername(username)
+			if err == gorm.ErrRecordNotFound {
+				return nil, fmt.Errorf("User \"%s\" does not exist", username)
+			}
+			if err != nil {
+				return nil, err
 			}
-
-			if err := db.AddTask(newTask, userIdOfContext(p)); err != nil {
+			task, err := db.TransferTask(id, userIdOfContext(p), recipient.Id)
+			if err != nil {
 				return nil, err
 			}
-			return newTask, nil
+			return task, nil
 		},
+		Description: "Transfers a task or habit to another user. Its dependencies are removed",
 	}
 
-	addHabitMutation := &graphql.Field{
-		Type: habitType,
+	deduplicateActionsMutation := &graphql.Field{
+		Type: graphql.Int,
 		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type: graphql.ID,
-			},
-			"title": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(graphql.String),
-			},
-			"interval": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(interval),
-			},
-			"frequency": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(graphql.Int),
-			},
-			"done": &graphql.ArgumentConfig{
-				Type: graphql.Boolean,
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id, _ := p.Args["id"].(string)
-			title, _ := p.Args["title"].(string)
-			interval, _ := p.Args["interval"].(Interval)
-			frequency, _ := p.Args["frequency"].(int)
-			done, _ := p.Args["done"].(bool)
+			taskId, _ := p.Args["taskId"].(string)
+			return db.DeduplicateActions(taskId, userIdOfContext(p))
+		},
+		Description: "Removes duplicate actions of the same kind and custom kind at the same minute and returns how many were removed",
+	}
 
-			newTask := &Task{
-				Id:        id,
-				Title:     title,
-				Interval:  interval,
-				Frequency: frequency,
-				Done:      done,
-				Kind:      HabitEnum,
+	updatePreferencesMutation := &graphql.Field{
+		Type: jsonType,
+		Args: graphql.FieldConfigArgument{
+			"preferences": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(jsonType),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			preferences, _ := p.Args["preferences"].(string)
+			if err := db.UpdatePreferences(userIdOfContext(p), preferences); err != nil {
+				return nil, err
 			}
+			return preferences, nil
+		},
+		Description: "Replaces the client settings stored for the user",
+	}
 
-			if err := db.AddTask(newTask, userIdOfContext(p)); err != nil {
+	createApiKeyMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "createApiKeyPayload",
+			Fields: graphql.Fields{
+				"key": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The API key. It can't be retrieved again after creation",
+				},
+				"apiKey": &graphql.Field{
+					Type: apiKeyType,
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"label": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			label, _ := p.Args["label"].(string)
+			key, apiKey, err := db.CreateApiKey(userIdOfContext(p), label)
+			if err != nil {
 				return nil, err
 			}
-			return newTask, nil
+			return map[string]interface{}{
+				"key":    key,
+				"apiKey": apiKey,
+			}, nil
 		},
 	}
 
-	deleteTaskMutation := &graphql.Field{
+	revokeApiKeyMutation := &graphql.Field{
 		Type: graphql.NewObject(graphql.ObjectConfig{
-			Name: "removeTaskPayload",
+			Name: "revokeApiKeyPayload",
 			Fields: graphql.Fields{
-				"deletedId": &graphql.Field{
+				"revokedId": &graphql.Field{
 					Type: graphql.ID,
 					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 						return p.Source, nil
@@ -365,97 +2128,150 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
-			taskDeleted, err := db.DeleteTask(id, userIdOfContext(p))
+			apiKeyId, err := strconv.ParseUint(id, 10, 64)
 			if err != nil {
-				return nil, err
+				return nil, ErrNotFound
 			}
-			if !taskDeleted {
-				return nil, nil
+			if err := db.RevokeApiKey(apiKeyId, userIdOfContext(p)); err != nil {
+				return nil, err
 			}
 			return id, nil
 		},
-		Description: "Deletes a task or habit by ID",
 	}
 
-	updateTaskMutation := &graphql.Field{
-		Type: taskType,
+	createActionKindMutation := &graphql.Field{
+		Type: customActionKindType,
 		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type: graphql.ID,
-			},
-			"title": &graphql.ArgumentConfig{
-				Type: graphql.String,
-			},
-			"start_date": &graphql.ArgumentConfig{
-				Type: dateType,
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
 			},
-			"end_date": &graphql.ArgumentConfig{
-				Type: dateType,
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			name, _ := p.Args["name"].(string)
+			kind, err := db.CreateActionKind(userIdOfContext(p), name)
+			if err != nil {
+				return nil, err
+			}
+			return kind, nil
+		},
+		Description: "Defines a custom kind of action",
+	}
+
+	deleteActionKindMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "deleteActionKindPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
 			},
-			"done": &graphql.ArgumentConfig{
-				Type: graphql.Boolean,
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
-
-			attrs := make(map[string]interface{})
-
-			if title, ok := p.Args["title"].(string); ok {
-				attrs["title"] = title
-			}
-			if startDate, ok := p.Args["start_date"].(*time.Time); ok {
-				attrs["start_date"] = startDate
-			}
-			if endDate, ok := p.Args["end_date"].(*time.Time); ok {
-				attrs["end_date"] = endDate
+			kindId, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				return nil, ErrNotFound
 			}
-			if done, ok := p.Args["done"].(bool); ok {
-				attrs["done"] = done
+			if err := db.DeleteActionKind(kindId, userIdOfContext(p)); err != nil {
+				return nil, err
 			}
-
-			return db.UpdateTask(id, userIdOfContext(p), attrs)
+			return id, nil
 		},
+		Description: "Deletes a custom kind of action. Existing actions of the kind are kept",
 	}
 
-	updateHabitMutation := &graphql.Field{
-		Type: habitType,
+	addAttachmentMutation := &graphql.Field{
+		Type: attachmentType,
 		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type: graphql.ID,
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
 			},
-			"title": &graphql.ArgumentConfig{
-				Type: graphql.String,
+			"url": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
 			},
-			"interval": &graphql.ArgumentConfig{
-				Type: interval,
+			"label": &graphql.ArgumentConfig{
+				Type: graphql.String,
 			},
-			"frequency": &graphql.ArgumentConfig{
-				Type: graphql.Int,
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			url, _ := p.Args["url"].(string)
+			label, _ := p.Args["label"].(string)
+			attachment, err := db.AddAttachment(taskId, userIdOfContext(p), url, label)
+			if err != nil {
+				return nil, err
+			}
+			return attachment, nil
+		},
+		Description: "Attaches a URL to a task or habit",
+	}
+
+	deleteAttachmentMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "deleteAttachmentPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
 			},
-			"done": &graphql.ArgumentConfig{
-				Type: graphql.Boolean,
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
-
-			attrs := make(map[string]interface{})
-
-			if title, ok := p.Args["title"].(string); ok {
-				attrs["title"] = title
-			}
-			if interval, ok := p.Args["interval"].(Interval); ok {
-				attrs["interval"] = interval
-			}
-			if frequency, ok := p.Args["frequency"].(int); ok {
-				attrs["frequency"] = frequency
+			attachmentId, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				return nil, ErrNotFound
 			}
-			if done, ok := p.Args["done"].(bool); ok {
-				attrs["done"] = done
+			if err := db.DeleteAttachment(attachmentId, userIdOfContext(p)); err != nil {
+				return nil, err
 			}
+			return id, nil
+		},
+	}
 
-			return db.UpdateTask(id, userIdOfContext(p), attrs)
+	dependencyArgs := graphql.FieldConfigArgument{
+		"taskId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.ID),
+		},
+		"dependsOnId": &graphql.ArgumentConfig{
+			Type:        graphql.NewNonNull(graphql.ID),
+			Description: "The task that must be done first",
+		},
+	}
+
+	addDependencyMutation := &graphql.Field{
+		Type: taskType,
+		Args: dependencyArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			dependsOnId, _ := p.Args["dependsOnId"].(string)
+			return db.AddDependency(taskId, dependsOnId, userIdOfContext(p))
+		},
+		Description: "Blocks a task until another task is done. Dependencies that would form a cycle are rejected",
+	}
+
+	removeDependencyMutation := &graphql.Field{
+		Type: taskType,
+		Args: dependencyArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			dependsOnId, _ := p.Args["dependsOnId"].(string)
+			return db.RemoveDependency(taskId, dependsOnId, userIdOfContext(p))
 		},
 	}
 
@@ -472,7 +2288,14 @@ func GetSchema(db Database) *graphql.Schema {
 				Type: graphql.NewNonNull(actionKind),
 			},
 			"when": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(dateType),
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"note": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"customKindId": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The custom kind of a CUSTOM action",
 			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
@@ -480,12 +2303,21 @@ func GetSchema(db Database) *graphql.Schema {
 			taskId, _ := p.Args["taskId"].(string)
 			kind, _ := p.Args["kind"].(ActionKind)
 			when, _ := p.Args["when"].(*time.Time)
+			note, _ := p.Args["note"].(string)
 
 			newAction := &Action{
 				Id:     id,
 				Kind:   kind,
 				When:   when,
 				TaskId: taskId,
+				Note:   note,
+			}
+			if customKindId, ok := p.Args["customKindId"].(string); ok {
+				kindId, err := strconv.ParseUint(customKindId, 10, 64)
+				if err != nil {
+					return nil, &ValidationError{"customKindId", "does not exist"}
+				}
+				newAction.CustomKindId = &kindId
 			}
 
 			if err := db.AddAction(newAction, userIdOfContext(p)); err != nil {
@@ -495,6 +2327,145 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 	}
 
+	actionInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "ActionInput",
+		Description: "An action to import",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"taskId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"kind": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(actionKind),
+			},
+			"when": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(dateTimeType),
+			},
+			"note": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"customKindId": &graphql.InputObjectFieldConfig{
+				Type:        graphql.ID,
+				Description: "The custom kind of a CUSTOM action",
+			},
+		},
+	})
+
+	importActionsMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "importActionsPayload",
+			Fields: graphql.Fields{
+				"imported": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"skipped": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The number of actions that duplicated one already recorded",
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"actions": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(actionInputType))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["actions"].([]interface{})
+			if len(inputs) > maxImportActions {
+				return nil, &ValidationError{"actions", fmt.Sprintf("must contain at most %d actions", maxImportActions)}
+			}
+			actions := make([]*Action, 0, len(inputs))
+			for _, input := range inputs {
+				fields, _ := input.(map[string]interface{})
+				id, _ := fields["id"].(string)
+				taskId, _ := fields["taskId"].(string)
+				kind, _ := fields["kind"].(ActionKind)
+				when, _ := fields["when"].(*time.Time)
+				note, _ := fields["note"].(string)
+
+				action := &Action{
+					Id:     id,
+					Kind:   kind,
+					When:   when,
+					TaskId: taskId,
+					Note:   note,
+				}
+				if customKindId, ok := fields["customKindId"].(string); ok {
+					kindId, err := strconv.ParseUint(customKindId, 10, 64)
+					if err != nil {
+						return nil, &ValidationError{"customKindId", "does not exist"}
+					}
+					action.CustomKindId = &kindId
+				}
+				actions = append(actions, action)
+			}
+
+			imported, skipped, err := db.ImportActions(userIdOfContext(p), actions)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"imported": imported,
+				"skipped":  skipped,
+			}, nil
+		},
+		Description: "Adds actions recorded offline, skipping ones already recorded on the same task with the same kind and custom kind in the same minute",
+	}
+
+	updateActionMutation := &graphql.Field{
+		Type: actionType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"when": &graphql.ArgumentConfig{
+				Type: dateTimeType,
+			},
+			"note": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+
+			attrs := make(map[string]interface{})
+
+			if when, ok := p.Args["when"].(*time.Time); ok {
+				attrs["when"] = when
+			}
+			if note, ok := p.Args["note"].(string); ok {
+				attrs["note"] = note
+			}
+
+			action, err := db.UpdateAction(id, userIdOfContext(p), attrs)
+			if err != nil {
+				return nil, err
+			}
+			return action, nil
+		},
+		Description: "Corrects the time or note of an action. Moving a DONE action on a task marks the task done again",
+	}
+
+	restoreActionMutation := &graphql.Field{
+		Type: actionType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			action, err := db.RestoreAction(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			return action, nil
+		},
+		Description: "Restores a deleted action. Restoring a DONE action on a task marks the task done again",
+	}
+
 	deleteActionMutation := &graphql.Field{
 		Type: graphql.NewObject(graphql.ObjectConfig{
 			Name: "removeActionPayload",
@@ -525,24 +2496,67 @@ func GetSchema(db Database) *graphql.Schema {
 	queryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "RootQuery",
 		Fields: graphql.Fields{
-			"task":   taskQuery,
-			"tasks":  tasksQuery,
-			"habit":  habitQuery,
-			"habits": habitsQuery,
-			"user":   userQuery,
+			"task":               taskQuery,
+			"tasks":              tasksQuery,
+			"habit":              habitQuery,
+			"habits":             habitsQuery,
+			"action":             actionQuery,
+			"apiKeys":            apiKeysQuery,
+			"timeline":           timelineQuery,
+			"stats":              statsQuery,
+			"user":               userQuery,
+			"preferences":        preferencesQuery,
+			"pendingCount":       pendingCountQuery,
+			"overdue":            overdueQuery,
+			"changes":            changesQuery,
+			"actionKinds":        actionKindsQuery,
+			"taskCounts":         taskCountsQuery,
+			"attachments":        attachmentsQuery,
+			"today":              todayQuery,
+			"tasksByIds":         tasksByIdsQuery,
+			"recentlyCompleted":  recentlyCompletedQuery,
+			"activity":           activityQuery,
+			"snapshots":          snapshotsQuery,
+			"previewOccurrences": previewOccurrencesQuery,
+			"signupStats":        signupStatsQuery,
+			"validateTasks":      validateTasksQuery,
+			"allActions":         allActionsQuery,
+			"quota":              quotaQuery,
 		},
 	})
 
 	mutationType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "RootMutation",
 		Fields: graphql.Fields{
-			"addTask":      addTaskMutation,
-			"deleteTask":   deleteTaskMutation,
-			"updateTask":   updateTaskMutation,
-			"addHabit":     addHabitMutation,
-			"updateHabit":  updateHabitMutation,
-			"addAction":    addActionMutation,
-			"deleteAction": deleteActionMutation,
+			"addTask":            addTaskMutation,
+			"deleteTask":         deleteTaskMutation,
+			"updateTask":         updateTaskMutation,
+			"addHabit":           addHabitMutation,
+			"updateHabit":        updateHabitMutation,
+			"transferTask":       transferTaskMutation,
+			"setTasksDone":       setTasksDoneMutation,
+			"createApiKey":       createApiKeyMutation,
+			"deduplicateActions": deduplicateActionsMutation,
+			"revokeApiKey":       revokeApiKeyMutation,
+			"addAction":          addActionMutation,
+			"deleteAction":       deleteActionMutation,
+			"pinTask":            pinTaskMutation,
+			"unpinTask":          unpinTaskMutation,
+			"updatePreferences":  updatePreferencesMutation,
+			"mergeTasks":         mergeTasksMutation,
+			"updateAction":       updateActionMutation,
+			"restoreTask":        restoreTaskMutation,
+			"restartHabit":       restartHabitMutation,
+			"createActionKind":   createActionKindMutation,
+			"deleteActionKind":   deleteActionKindMutation,
+			"addAttachment":      addAttachmentMutation,
+			"deleteAttachment":   deleteAttachmentMutation,
+			"restoreAction":      restoreActionMutation,
+			"addTasks":           addTasksMutation,
+			"importActions":      importActionsMutation,
+			"archiveCompleted":   archiveCompletedMutation,
+			"addDependency":      addDependencyMutation,
+			"removeDependency":   removeDependencyMutation,
 		},
 	})
 
@@ -554,6 +2568,7 @@ func GetSchema(db Database) *graphql.Schema {
 	if err != nil {
 		panic(err)
 	}
+	recoverResolvers(&schema)
 
 	return &schema
 }
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"testing"
+)
+
+// Returns the deprecation reason of each deprecated field of a type, as
+// reported by introspection.
+func deprecatedFields(t *testing.T, typeName string) map[string]string {
+	db, _ := newFakeDatabase(t, systemClock{})
+	data := runQuery(t, db, `query($name: String!) {
+		__type(name: $name) { fields(includeDeprecated: true) { name isDeprecated deprecationReason } }
+	}`, map[string]interface{}{"name": typeName})
+	deprecated := make(map[string]string)
+	fields := data["__type"].(map[string]interface{})["fields"].([]interface{})
+	for _, field := range fields {
+		field := field.(map[string]interface{})
+		if field["isDeprecated"] == true {
+			reason, _ := field["deprecationReason"].(string)
+			deprecated[field["name"].(string)] = reason
+		}
+	}
+	return deprecated
+}
+
+func TestHabitDoneIsDeprecated(t *testing.T) {
+	habit := deprecatedFields(t, "Habit")
+	if reason, ok := habit["done"]; !ok || reason == "" {
+		t.Errorf("Habit.done: got deprecated %t with reason %q, want a reason", ok, reason)
+	}
+	if len(habit) != 1 {
+		t.Errorf("got deprecated Habit fields %v, want only done", habit)
+	}
+	if task := deprecatedFields(t, "Task"); len(task) > 0 {
+		t.Errorf("got deprecated Task fields %v, want none", task)
+	}
+}
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Sets the session settings for a test, returning a func restoring them.
+func setSessionSettings(timeout, refreshWindow, maxLifetime time.Duration) func() {
+	savedTimeout, savedWindow, savedLifetime := sessionTimeout, sessionRefreshWindow, sessionMaxLifetime
+	sessionTimeout, sessionRefreshWindow, sessionMaxLifetime = timeout, refreshWindow, maxLifetime
+	return func() {
+		sessionTimeout, sessionRefreshWindow, sessionMaxLifetime = savedTimeout, savedWindow, savedLifetime
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	defer setSessionSettings(2*time.Hour, time.Hour, 24*time.Hour)()
+	// Refreshed tokens are parsed, so they must not have expired yet
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name      string
+		authTime  time.Time
+		expiresAt time.Time
+		// When the refreshed token expires, or zero if it isn't refreshed
+		want time.Time
+	}{
+		{"within the window", now.Add(-3 * time.Hour), now.Add(30 * time.Minute), now.Add(2 * time.Hour)},
+		{"outside the window", now.Add(-time.Hour), now.Add(90 * time.Minute), time.Time{}},
+		{"capped by the max lifetime", now.Add(-23 * time.Hour), now.Add(30 * time.Minute), now.Add(time.Hour)},
+		{"at the max lifetime", now.Add(-23*time.Hour - 30*time.Minute), now.Add(30 * time.Minute), time.Time{}},
+	}
+	for _, test := range tests {
+		claims := DuetClaims{Username: "alice", AuthTime: test.authTime.Unix()}
+		claims.Subject = "7"
+		claims.ExpiresAt = test.expiresAt.Unix()
+		refreshed, err := refreshToken(claims, now)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if test.want.IsZero() {
+			if refreshed != "" {
+				t.Errorf("%s: got a refreshed token, want none", test.name)
+			}
+			continue
+		}
+		got, err := parseToken(refreshed)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got.ExpiresAt != test.want.Unix() || got.AuthTime != claims.AuthTime || got.Subject != "7" || got.Username != "alice" {
+			t.Errorf("%s: got claims %+v, want the same session expiring at %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSlideSession(t *testing.T) {
+	defer setSessionSettings(2*time.Hour, time.Hour, 24*time.Hour)()
+	user := &User{Id: 7, Username: "alice"}
+	tests := []struct {
+		name     string
+		authTime time.Time
+		// Whether the response has a refreshed token
+		refreshed bool
+	}{
+		// Tokens are issued for the timeout, so ones for sessions that reach
+		// their max lifetime within the window are due for a refresh
+		{"fresh token", time.Now(), false},
+		{"near the max lifetime", time.Now().Add(-23*time.Hour - 30*time.Minute), false},
+	}
+	for _, test := range tests {
+		token, err := newToken(user, test.authTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/graphql", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		header := http.Header{}
+		SlideSession(header, r)
+		if got := header.Get(refreshedTokenHeader) != ""; got != test.refreshed {
+			t.Errorf("%s: got refreshed %t, want %t", test.name, got, test.refreshed)
+		}
+	}
+
+	// A token whose timeout is mostly used up is refreshed
+	sessionTimeout = 30 * time.Minute
+	token, err := newToken(user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionTimeout = 2 * time.Hour
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	header := http.Header{}
+	SlideSession(header, r)
+	claims, err := VerifyToken(header.Get(refreshedTokenHeader))
+	if err != nil {
+		t.Fatalf("got no valid refreshed token: %s", err)
+	}
+	if claims.ExpiresAt <= time.Now().Add(30*time.Minute).Unix() {
+		t.Errorf("got a refreshed token expiring at %d, want later than the original", claims.ExpiresAt)
+	}
+}
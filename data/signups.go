@@ -0,0 +1,28 @@
+package data
+
+import (
+	"time"
+)
+
+// DailyCount is the number of events on a UTC day.
+type DailyCount struct {
+	Date  time.Time `json:"date"`
+	Count int64     `json:"count"`
+}
+
+// Returns the number of users who signed up on each UTC day between from
+// (inclusive) and to (exclusive). Days without signups are left out.
+// Deactivated users are still counted.
+func (db gormDB) GetSignupStats(from time.Time, to time.Time) ([]DailyCount, error) {
+	counts := []DailyCount{}
+	err := db.Unscoped().Table("users").
+		Select("date_trunc('day', created_at AT TIME ZONE 'UTC') AS date, count(*) AS count").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("1").
+		Order("1").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
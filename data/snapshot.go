@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DailySnapshot is the number of completions a user recorded on a UTC day,
+// stored so historical charts don't change when actions are later edited or
+// deleted.
+type DailySnapshot struct {
+	UserId      uint64    `json:"user_id" gorm:"primary_key;auto_increment:false"`
+	Date        time.Time `json:"date" gorm:"primary_key;type:date"`
+	Completions int64     `json:"completions" gorm:"not_null"`
+}
+
+// Records every user's completions on the UTC day containing day, replacing
+// any snapshots already taken for it.
+func (db gormDB) SnapshotDay(day time.Time) error {
+	start := periodStart(Daily, day)
+	end := start.AddDate(0, 0, 1)
+
+	tx := db.Begin()
+	if err := tx.Where("date = ?", start).Delete(&DailySnapshot{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	err := tx.Exec(`INSERT INTO daily_snapshots (user_id, date, completions)
+		SELECT tasks.user_id, ?, count(*) FROM actions
+		JOIN tasks ON tasks.id = actions.task_id
+		WHERE tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions.kind = ? AND actions."when" >= ? AND actions."when" < ?
+		GROUP BY tasks.user_id`, start, ActionDone, start, end).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Returns the user's snapshots for the days from from to to, both inclusive.
+// Days without completions have no snapshot.
+func (db gormDB) GetSnapshots(userId uint64, from time.Time, to time.Time) ([]DailySnapshot, error) {
+	snapshots := []DailySnapshot{}
+	err := db.Where("user_id = ? AND date >= ? AND date <= ?", userId, periodStart(Daily, from), periodStart(Daily, to)).
+		Order("date").
+		Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// How long after midnight UTC the previous day is snapshotted, leaving time
+// for actions recorded just before midnight to arrive.
+const snapshotDelay = 5 * time.Minute
+
+// RunDailySnapshots snapshots the previous day when it starts and then shortly
+// after each midnight UTC until ctx is cancelled.
+func RunDailySnapshots(ctx context.Context, db Database) {
+	for {
+		now := db.Now()
+		today := periodStart(Daily, now)
+		if err := db.SnapshotDay(today.AddDate(0, 0, -1)); err != nil {
+			log.Printf("Taking daily snapshots failed, %s", err.Error())
+		}
+
+		next := today.AddDate(0, 0, 1).Add(snapshotDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"time"
+)
+
+// Returns the number of the user's one-off tasks that are done.
+func (db gormDB) CountCompletedTasks(userId uint64) (int64, error) {
+	var count int64
+	err := db.reader().Model(&Task{}).
+		Where("user_id = ? AND kind = ? AND done = ?", userId, TaskEnum, true).
+		Count(&count).Error
+	return count, err
+}
+
+// Counts the actions on the user's tasks and habits dated at or after since.
+func (db gormDB) CountActionsSince(userId uint64, since time.Time) (int64, error) {
+	var count int64
+	err := db.reader().Table("actions").
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where(`tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions."when" >= ?`, userId, since).
+		Count(&count).Error
+	return count, err
+}
+
+// Returns the longest current streak across the user's habits.
+func (db gormDB) GetLongestCurrentStreak(userId uint64) (int, error) {
+	db = db.reader()
+	streaks, err := db.habitStreaks(userId, db.Now())
+	if err != nil {
+		return 0, err
+	}
+	longest := 0
+	for _, streak := range streaks {
+		if streak > longest {
+			longest = streak
+		}
+	}
+	return longest, nil
+}
+
+// Returns the number of the user's tasks of each kind.
+func (db gormDB) CountTasksByKind(userId uint64) (map[TaskKind]int64, error) {
+	db = db.reader()
+	var rows []struct {
+		Kind  TaskKind
+		Count int64
+	}
+	err := db.Table("tasks").
+		Select("kind, count(*) AS count").
+		Where("user_id = ? AND deleted_at IS NULL", userId).
+		Group("kind").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[TaskKind]int64{
+		TaskEnum:  0,
+		HabitEnum: 0,
+	}
+	for _, row := range rows {
+		counts[row.Kind] = row.Count
+	}
+	return counts, nil
+}
+
+// Returns how many tasks and habits the user has and the most they may have,
+// which is 0 if there's no limit.
+func (db gormDB) GetQuotaStatus(userId uint64) (int64, int64, error) {
+	counts, err := db.CountTasksByKind(userId)
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts[TaskEnum] + counts[HabitEnum], int64(maxTasksPerUser), nil
+}
+
+// Returns the current streak of each of the user's habits by ID.
+func (db gormDB) habitStreaks(userId uint64, now time.Time) (map[string]int, error) {
+	var habits []Task
+	if err := db.Where("user_id = ? AND kind = ?", userId, HabitEnum).Find(&habits).Error; err != nil {
+		return nil, err
+	}
+
+	var completions []struct {
+		TaskId string
+		When   time.Time
+	}
+	err := db.Table("actions").
+		Select(`actions.task_id, actions."when"`).
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where("tasks.user_id = ? AND tasks.kind = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions.kind = ?", userId, HabitEnum, ActionDone).
+		Scan(&completions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	restarts := make(map[string]*time.Time)
+	for _, habit := range habits {
+		restarts[habit.Id] = habit.RestartedAt
+	}
+	doneTimes := make(map[string][]time.Time)
+	for _, completion := range completions {
+		if restart := restarts[completion.TaskId]; restart != nil && completion.When.Before(*restart) {
+			continue
+		}
+		doneTimes[completion.TaskId] = append(doneTimes[completion.TaskId], completion.When)
+	}
+
+	streaks := make(map[string]int)
+	for _, habit := range habits {
+		streaks[habit.Id] = currentStreak(habit.Interval, habit.Frequency, doneTimes[habit.Id], now)
+	}
+	return streaks, nil
+}
+
+// Returns the number of tasks and habits that need the user's attention.
+// A task is pending until it's done. A habit is pending while its frequency
+// hasn't been met in the current period.
+func (db gormDB) CountPendingTasks(userId uint64) (int64, error) {
+	db = db.reader()
+	now := db.Now()
+	habitPending, habitArgs := habitPendingCondition(now)
+	var count int64
+	err := db.Model(&Task{}).
+		Where("user_id = ? AND done = ?", userId, false).
+		Where("kind = ? OR (kind = ? AND "+habitPending+")", append([]interface{}{TaskEnum, HabitEnum}, habitArgs...)...).
+		Count(&count).Error
+	return count, err
+}
+
+// Returns the user's tasks that are past their end date without being done,
+// and their habits whose frequency hasn't been met in the current period.
+func (db gormDB) GetOverdueTasks(userId uint64, now time.Time) ([]Task, error) {
+	habitPending, habitArgs := habitPendingCondition(now)
+	var tasks []Task
+	err := db.Preload("Actions").
+		Where("user_id = ? AND done = ?", userId, false).
+		Where("(kind = ? AND end_date < ?) OR (kind = ? AND "+habitPending+")",
+			append([]interface{}{TaskEnum, now, HabitEnum}, habitArgs...)...).
+		Order("created_at").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Counts the done actions on the user's task dated at or after since.
+func (db gormDB) CountCompletionsSince(taskId string, userId uint64, since time.Time) (int64, error) {
+	var count int64
+	err := db.Table("actions").
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where(`tasks.id = ? AND tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions.kind = ? AND actions."when" >= ?`,
+			taskId, userId, ActionDone, since).
+		Count(&count).Error
+	return count, err
+}
+
+// Counts the progress actions on the user's task since it was last marked
+// done, or since it was created if it never has been.
+func (db gormDB) CountProgressSinceDone(taskId string, userId uint64) (int64, error) {
+	var count int64
+	err := db.reader().Table("actions").
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where(`tasks.id = ? AND tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions.kind = ?`,
+			taskId, userId, ActionProgress).
+		Where(`actions."when" > COALESCE((
+			SELECT max(done."when") FROM actions done
+			WHERE done.task_id = tasks.id AND done.deleted_at IS NULL AND done.kind = ?
+		), '-infinity')`, ActionDone).
+		Count(&count).Error
+	return count, err
+}
+
+// Returns up to limit of the user's tasks and habits that have been marked
+// done, most recently completed first.
+func (db gormDB) GetRecentlyCompleted(userId uint64, limit int) ([]Task, error) {
+	var tasks []Task
+	err := db.Preload("Actions").
+		Select("tasks.*").
+		Joins(`JOIN (
+			SELECT task_id, max("when") AS last_done FROM actions
+			WHERE kind = ? AND deleted_at IS NULL
+			GROUP BY task_id
+		) completions ON completions.task_id = tasks.id`, ActionDone).
+		Where("tasks.user_id = ?", userId).
+		Order("completions.last_done DESC").
+		Limit(limit).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Returns a condition on the tasks table matching habits with fewer done
+// actions than their frequency in the period containing now.
+func habitPendingCondition(now time.Time) (string, []interface{}) {
+	condition := `frequency > (
+		SELECT count(*) FROM actions
+		WHERE actions.task_id = tasks.id AND actions.deleted_at IS NULL AND actions.kind = ? AND actions."when" >=
+			CASE tasks."interval" WHEN ? THEN ? WHEN ? THEN ? ELSE ? END)`
+	args := []interface{}{ActionDone,
+		Daily, periodStart(Daily, now), Weekly, periodStart(Weekly, now), periodStart(Monthly, now)}
+	return condition, args
+}
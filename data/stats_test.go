@@ -0,0 +1,92 @@
+package data
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+// Returns actions of the kind on a task, one a minute from start.
+func actionRows(taskId string, kind ActionKind, start time.Time, count int) [][]driver.Value {
+	var rows [][]driver.Value
+	for i := 0; i < count; i++ {
+		rows = append(rows, []driver.Value{fmt.Sprintf("%s-%d-%d", taskId, kind, i), int64(kind), start.Add(time.Duration(i) * time.Minute), taskId})
+	}
+	return rows
+}
+
+func TestProgressCountIsScoped(t *testing.T) {
+	start := mustTime(t, "2017-01-10T12:00:00Z")
+	tests := []struct {
+		name string
+		// The actions loaded with the task, newest first
+		loaded [][]driver.Value
+		// What the database counts
+		counted int64
+	}{
+		{"no actions", nil, 0},
+		{"progress after done", append(actionRows("t", ActionProgress, start.Add(time.Hour), 3), doneRows("t", start)...), 3},
+		{"done after progress", append(doneRows("t", start.Add(time.Hour)), actionRows("t", ActionProgress, start, 3)...), 0},
+		{"more progress than is loaded", actionRows("t", ActionProgress, start, defaultRecentActions), defaultRecentActions + 5},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, fixedClock(start),
+			fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{test.counted}}},
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("t", start)}},
+			fakeResult{`FROM "actions"`, actionColumns, test.loaded},
+		)
+		data := runQuery(t, db, `{ task(id: "t") { progressCount } }`, nil)
+		count := data["task"].(map[string]interface{})["progressCount"]
+		if count != int(test.counted) {
+			t.Errorf("%s: got a progress count of %v, want %d", test.name, count, test.counted)
+		}
+
+		counts := 0
+		for _, statement := range conn.sent() {
+			if !strings.Contains(statement.query, "count(*)") {
+				continue
+			}
+			counts++
+			if !hasArg(statement.args, "t") || !hasArg(statement.args, int64(ActionProgress)) || !hasArg(statement.args, int64(ActionDone)) {
+				t.Errorf("%s: counted with %v, want the task's progress since its last done", test.name, statement.args)
+			}
+		}
+		if counts != 1 {
+			t.Errorf("%s: got %d counts, want 1", test.name, counts)
+		}
+	}
+}
+
+func TestStatsPartialFailure(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	db, conn := newFakeDatabase(t, fixedClock(now),
+		fakeResult{"GROUP BY kind", []string{"kind", "count"}, [][]driver.Value{{int64(TaskEnum), int64(3)}, {int64(HabitEnum), int64(2)}}},
+		fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(4)}}},
+	)
+	// Counting the completed tasks fails, which shouldn't lose the rest
+	conn.failOn("done = $", fmt.Errorf("canceling statement due to statement timeout"))
+
+	result := graphql.Do(graphql.Params{
+		Schema:        *GetSchema(db),
+		RequestString: `{ stats { tasks habits completed_tasks actions_this_week } }`,
+		Context:       context.WithValue(context.Background(), UserIdKey, uint64(1)),
+	})
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Message, "statement timeout") {
+		t.Errorf("got errors %v, want the one from counting completed tasks", result.Errors)
+	}
+	stats, ok := result.Data.(map[string]interface{})["stats"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got %v, want the stats that succeeded", result.Data)
+	}
+	want := map[string]interface{}{"tasks": 3, "habits": 2, "completed_tasks": nil, "actions_this_week": 4}
+	for field, value := range want {
+		if stats[field] != value {
+			t.Errorf("%s: got %v, want %v", field, stats[field], value)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Returns the start of the habit period containing t. Days start at midnight
+// UTC, weeks on Monday and months on the first.
+func periodStart(interval Interval, t time.Time) time.Time {
+	return periodStartIn(interval, t, time.UTC)
+}
+
+// Returns the start of the habit period containing t with days starting at
+// midnight in loc.
+func periodStartIn(interval Interval, t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	switch interval {
+	case Weekly:
+		daysSinceMonday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -daysSinceMonday)
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return day
+	}
+}
+
+// Returns the start of the period before the one starting at start.
+func previousPeriod(interval Interval, start time.Time) time.Time {
+	switch interval {
+	case Weekly:
+		return start.AddDate(0, 0, -7)
+	case Monthly:
+		return start.AddDate(0, -1, 0)
+	default:
+		return start.AddDate(0, 0, -1)
+	}
+}
+
+// Counts completions by the Unix time of the start of their period.
+func completionsByPeriod(interval Interval, doneTimes []time.Time) map[int64]int {
+	counts := make(map[int64]int)
+	for _, t := range doneTimes {
+		counts[periodStart(interval, t).Unix()]++
+	}
+	return counts
+}
+
+// Returns the number of consecutive periods up to now in which the habit was
+// completed frequency times. The current period only breaks the streak once
+// it's over, so an unfinished today doesn't reset it.
+func currentStreak(interval Interval, frequency int, doneTimes []time.Time, now time.Time) int {
+	if frequency < 1 {
+		frequency = 1
+	}
+	counts := completionsByPeriod(interval, doneTimes)
+
+	start := periodStart(interval, now)
+	if counts[start.Unix()] < frequency {
+		start = previousPeriod(interval, start)
+	}
+	streak := 0
+	for counts[start.Unix()] >= frequency {
+		streak++
+		start = previousPeriod(interval, start)
+	}
+	return streak
+}
+
+// Returns when the habit's current period started in loc, or when it was
+// restarted if that was later.
+func habitCurrentPeriodStart(habit *Task, now time.Time, loc *time.Location) time.Time {
+	start := periodStartIn(habit.Interval, now, loc)
+	if habit.RestartedAt != nil && habit.RestartedAt.After(start) {
+		return *habit.RestartedAt
+	}
+	return start
+}
+
+// Returns the most consecutive periods in which the habit was completed
+// frequency times.
+func longestStreak(interval Interval, frequency int, doneTimes []time.Time) int {
+	if frequency < 1 {
+		frequency = 1
+	}
+	var met []time.Time
+	for start, count := range completionsByPeriod(interval, doneTimes) {
+		if count >= frequency {
+			met = append(met, time.Unix(start, 0).UTC())
+		}
+	}
+	sort.Sort(timesAscending(met))
+
+	longest, run := 0, 0
+	for i, start := range met {
+		if i > 0 && nextPeriod(interval, met[i-1]).Equal(start) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	return longest
+}
+
+type timesAscending []time.Time
+
+func (t timesAscending) Len() int           { return len(t) }
+func (t timesAscending) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timesAscending) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// Returns the longest streak of a habit with its actions loaded. Unlike the
+// current streak this counts completions from before a restart, so a restart
+// doesn't erase the habit's best run.
+func habitLongestStreak(habit *Task) int {
+	var doneTimes []time.Time
+	for _, action := range habit.Actions {
+		if action.Kind == ActionDone && action.When != nil {
+			doneTimes = append(doneTimes, *action.When)
+		}
+	}
+	return longestStreak(habit.Interval, habit.Frequency, doneTimes)
+}
+
+// Returns the times the habit was completed since it was last restarted.
+func habitDoneTimes(habit *Task) []time.Time {
+	var doneTimes []time.Time
+	for _, action := range habit.Actions {
+		if action.Kind != ActionDone || action.When == nil {
+			continue
+		}
+		if habit.RestartedAt != nil && action.When.Before(*habit.RestartedAt) {
+			continue
+		}
+		doneTimes = append(doneTimes, *action.When)
+	}
+	return doneTimes
+}
+
+// Returns the current streak of a habit with its actions loaded.
+func habitStreak(habit *Task, now time.Time) int {
+	return currentStreak(habit.Interval, habit.Frequency, habitDoneTimes(habit), now)
+}
+
+// Returns when the user should next be reminded of a habit, which is its
+// reminder lead before the end of the current period if it hasn't been met
+// and the reminder is still to come, or else before the end of the next
+// period. Habits without a reminder lead or that are done have no reminders.
+func habitNextReminder(habit *Task, now time.Time, loc *time.Location) *time.Time {
+	if habit.ReminderLead <= 0 || habit.Done {
+		return nil
+	}
+	start := periodStartIn(habit.Interval, now, loc)
+	end := nextPeriod(habit.Interval, start)
+	reminder := reminderBefore(start, end, habit.ReminderLead)
+	if !habitPendingIn(habit, now, loc) || reminder.Before(now) {
+		reminder = reminderBefore(end, nextPeriod(habit.Interval, end), habit.ReminderLead)
+	}
+	return &reminder
+}
+
+// Returns lead before the end of a period, or its start if the lead is longer
+// than the period.
+func reminderBefore(start time.Time, end time.Time, lead time.Duration) time.Time {
+	reminder := end.Add(-lead)
+	if reminder.Before(start) {
+		return start
+	}
+	return reminder
+}
+
+// The most occurrences PreviewOccurrences returns, which bounds the range
+// that can be previewed at once.
+const maxPreviewOccurrences = 1000
+
+// PreviewOccurrences returns when a habit with the interval and frequency
+// would be due between from (inclusive) and to (exclusive), with each
+// period's completions spread evenly over it.
+func PreviewOccurrences(interval Interval, frequency int, from time.Time, to time.Time) ([]time.Time, error) {
+	if err := validateInterval(interval); err != nil {
+		return nil, err
+	}
+	if err := validateFrequency(interval, frequency); err != nil {
+		return nil, err
+	}
+	if !from.Before(to) {
+		return nil, &ValidationError{"to", "must be after from"}
+	}
+
+	occurrences := []time.Time{}
+	for start := periodStart(interval, from); start.Before(to); start = nextPeriod(interval, start) {
+		length := nextPeriod(interval, start).Sub(start)
+		for i := 0; i < frequency; i++ {
+			occurrence := start.Add(length * time.Duration(i) / time.Duration(frequency))
+			if occurrence.Before(from) || !occurrence.Before(to) {
+				continue
+			}
+			if len(occurrences) == maxPreviewOccurrences {
+				return nil, &ValidationError{"to", fmt.Sprintf("the range has more than %d occurrences", maxPreviewOccurrences)}
+			}
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences, nil
+}
+
+// Returns when the habit's next completion is expected, spreading its
+// frequency evenly over the interval after the last completion. Habits that
+// have never been completed are due from when they were created, and habits
+// marked done are retired and never due.
+func habitNextDue(habit *Task) *time.Time {
+	if habit.Kind != HabitEnum || habit.Done {
+		return nil
+	}
+
+	var last *time.Time
+	for i := range habit.Actions {
+		action := &habit.Actions[i]
+		if action.Kind == ActionDone && action.When != nil && (last == nil || action.When.After(*last)) {
+			last = action.When
+		}
+	}
+	if last == nil {
+		due := habit.CreatedAt
+		return &due
+	}
+
+	var periodEnd time.Time
+	switch habit.Interval {
+	case Weekly:
+		periodEnd = last.AddDate(0, 0, 7)
+	case Monthly:
+		periodEnd = last.AddDate(0, 1, 0)
+	default:
+		periodEnd = last.AddDate(0, 0, 1)
+	}
+	frequency := habit.Frequency
+	if frequency < 1 {
+		frequency = 1
+	}
+	due := last.Add(periodEnd.Sub(*last) / time.Duration(frequency))
+	return &due
+}
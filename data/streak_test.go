@@ -0,0 +1,171 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Parses each of the values as RFC 3339 times.
+func mustTimes(t *testing.T, values ...string) []time.Time {
+	var times []time.Time
+	for _, value := range values {
+		times = append(times, mustTime(t, value))
+	}
+	return times
+}
+
+func TestPeriodStartIn(t *testing.T) {
+	toronto, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		interval Interval
+		t        string
+		loc      *time.Location
+		start    string
+	}{
+		{Daily, "2017-01-10T00:00:00Z", time.UTC, "2017-01-10T00:00:00Z"},
+		{Daily, "2017-01-10T23:59:59Z", time.UTC, "2017-01-10T00:00:00Z"},
+		{Daily, "2017-01-11T04:59:59Z", toronto, "2017-01-10T05:00:00Z"},
+		// January 16th 2017 is a Monday
+		{Weekly, "2017-01-16T00:00:00Z", time.UTC, "2017-01-16T00:00:00Z"},
+		{Weekly, "2017-01-22T23:59:59Z", time.UTC, "2017-01-16T00:00:00Z"},
+		{Weekly, "2017-01-15T23:59:59Z", time.UTC, "2017-01-09T00:00:00Z"},
+		{Weekly, "2017-01-16T04:59:59Z", toronto, "2017-01-09T05:00:00Z"},
+		{Monthly, "2017-02-28T23:59:59Z", time.UTC, "2017-02-01T00:00:00Z"},
+		{Monthly, "2017-03-01T04:59:59Z", toronto, "2017-02-01T05:00:00Z"},
+	}
+	for _, test := range tests {
+		start := periodStartIn(test.interval, mustTime(t, test.t), test.loc)
+		if !start.Equal(mustTime(t, test.start)) {
+			t.Errorf("%s in %s: got a %s period starting at %s, want %s",
+				test.t, test.loc, intervalNames[test.interval], start, test.start)
+		}
+	}
+}
+
+func TestCurrentStreak(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  Interval
+		frequency int
+		done      []string
+		now       string
+		streak    int
+	}{
+		{"never done", Daily, 1, nil, "2017-01-10T12:00:00Z", 0},
+		{"done today", Daily, 1, []string{"2017-01-10T08:00:00Z"}, "2017-01-10T12:00:00Z", 1},
+		{"today not done yet", Daily, 1, []string{"2017-01-08T08:00:00Z", "2017-01-09T08:00:00Z"}, "2017-01-10T12:00:00Z", 2},
+		{"gap", Daily, 1, []string{"2017-01-07T08:00:00Z", "2017-01-09T08:00:00Z", "2017-01-10T08:00:00Z"}, "2017-01-10T12:00:00Z", 2},
+		{"frequency met", Daily, 2, []string{"2017-01-09T08:00:00Z", "2017-01-09T20:00:00Z"}, "2017-01-10T12:00:00Z", 1},
+		{"frequency not met", Daily, 2, []string{"2017-01-09T08:00:00Z"}, "2017-01-10T12:00:00Z", 0},
+		{"frequency of 0", Daily, 0, []string{"2017-01-09T08:00:00Z"}, "2017-01-10T12:00:00Z", 1},
+		{"weeks", Weekly, 1, []string{"2017-01-02T08:00:00Z", "2017-01-15T08:00:00Z"}, "2017-01-17T12:00:00Z", 2},
+		{"months", Monthly, 1, []string{"2016-12-31T08:00:00Z", "2017-01-01T08:00:00Z"}, "2017-02-10T12:00:00Z", 2},
+	}
+	for _, test := range tests {
+		streak := currentStreak(test.interval, test.frequency, mustTimes(t, test.done...), mustTime(t, test.now))
+		if streak != test.streak {
+			t.Errorf("%s: got a streak of %d, want %d", test.name, streak, test.streak)
+		}
+	}
+}
+
+func TestLongestStreak(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  Interval
+		frequency int
+		done      []string
+		streak    int
+	}{
+		{"never done", Daily, 1, nil, 0},
+		{"one day", Daily, 1, []string{"2017-01-10T08:00:00Z"}, 1},
+		{"earlier run is longest", Daily, 1, []string{
+			"2017-01-01T08:00:00Z", "2017-01-02T08:00:00Z", "2017-01-03T08:00:00Z",
+			"2017-01-09T08:00:00Z", "2017-01-10T08:00:00Z",
+		}, 3},
+		{"out of order", Daily, 1, []string{"2017-01-03T08:00:00Z", "2017-01-01T08:00:00Z", "2017-01-02T08:00:00Z"}, 3},
+		{"frequency breaks the run", Daily, 2, []string{
+			"2017-01-01T08:00:00Z", "2017-01-01T20:00:00Z",
+			"2017-01-02T08:00:00Z",
+			"2017-01-03T08:00:00Z", "2017-01-03T20:00:00Z",
+		}, 1},
+		{"months of different lengths", Monthly, 1, []string{"2017-01-31T08:00:00Z", "2017-02-28T08:00:00Z", "2017-03-01T08:00:00Z"}, 3},
+	}
+	for _, test := range tests {
+		streak := longestStreak(test.interval, test.frequency, mustTimes(t, test.done...))
+		if streak != test.streak {
+			t.Errorf("%s: got a longest streak of %d, want %d", test.name, streak, test.streak)
+		}
+	}
+}
+
+func TestHabitStreakSinceRestart(t *testing.T) {
+	restartedAt := mustTime(t, "2017-01-09T12:00:00Z")
+	habit := &Task{Kind: HabitEnum, Interval: Daily, Frequency: 1, RestartedAt: &restartedAt}
+	for _, when := range mustTimes(t, "2017-01-07T08:00:00Z", "2017-01-08T08:00:00Z", "2017-01-09T08:00:00Z", "2017-01-10T08:00:00Z") {
+		when := when
+		habit.Actions = append(habit.Actions, Action{Kind: ActionDone, When: &when})
+	}
+
+	// Completions from before the restart only count towards the longest streak
+	if streak := habitStreak(habit, mustTime(t, "2017-01-10T12:00:00Z")); streak != 1 {
+		t.Errorf("got a streak of %d, want 1", streak)
+	}
+	if longest := habitLongestStreak(habit); longest != 4 {
+		t.Errorf("got a longest streak of %d, want 4", longest)
+	}
+}
+
+func TestPreviewOccurrences(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    Interval
+		frequency   int
+		from        string
+		to          string
+		occurrences []string
+		// A substring of the error, if the preview is rejected
+		err string
+	}{
+		{"daily", Daily, 1, "2017-01-10T00:00:00Z", "2017-01-12T00:00:00Z",
+			[]string{"2017-01-10T00:00:00Z", "2017-01-11T00:00:00Z"}, ""},
+		{"spread over the day", Daily, 2, "2017-01-10T00:00:00Z", "2017-01-11T00:00:00Z",
+			[]string{"2017-01-10T00:00:00Z", "2017-01-10T12:00:00Z"}, ""},
+		{"starting mid-period", Daily, 2, "2017-01-10T06:00:00Z", "2017-01-11T06:00:00Z",
+			[]string{"2017-01-10T12:00:00Z", "2017-01-11T00:00:00Z"}, ""},
+		{"weekly from Monday", Weekly, 1, "2017-01-11T00:00:00Z", "2017-01-24T00:00:00Z",
+			[]string{"2017-01-16T00:00:00Z", "2017-01-23T00:00:00Z"}, ""},
+		{"empty range", Daily, 1, "2017-01-10T00:00:00Z", "2017-01-10T00:00:00Z", nil, "must be after from"},
+		{"invalid interval", Interval(9), 1, "2017-01-10T00:00:00Z", "2017-01-11T00:00:00Z", nil, "not an interval"},
+		{"invalid frequency", Daily, 0, "2017-01-10T00:00:00Z", "2017-01-11T00:00:00Z", nil, "at least 1"},
+		{"too many", Daily, 24, "2017-01-01T00:00:00Z", "2017-03-01T00:00:00Z", nil, "more than 1000 occurrences"},
+	}
+	for _, test := range tests {
+		occurrences, err := PreviewOccurrences(test.interval, test.frequency, mustTime(t, test.from), mustTime(t, test.to))
+		if test.err != "" {
+			if _, ok := err.(*ValidationError); !ok || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want a ValidationError containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		want := mustTimes(t, test.occurrences...)
+		if len(occurrences) != len(want) {
+			t.Errorf("%s: got %v, want %v", test.name, occurrences, want)
+			continue
+		}
+		for i := range want {
+			if !occurrences[i].Equal(want[i]) {
+				t.Errorf("%s: got %v, want %v", test.name, occurrences, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+func TestGetTasksByIds(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	// Only the user's own tasks match the query, so the fake returns those
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("mine", now), taskRow("also-mine", now)}},
+		fakeResult{`FROM "actions"`, actionColumns, doneRows("mine", now)},
+	)
+	tasks, err := db.GetTasksByIds([]string{"mine", "theirs", "also-mine"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Id != "mine" || tasks[1].Id != "also-mine" || len(tasks[0].Actions) != 1 {
+		t.Errorf("got %+v, want the two owned tasks", tasks)
+	}
+
+	var queries []fakeStatement
+	for _, statement := range conn.sent() {
+		if strings.Contains(statement.query, `FROM "tasks"`) {
+			queries = append(queries, statement)
+		}
+	}
+	if len(queries) != 1 || !strings.Contains(queries[0].query, "user_id = $") {
+		t.Fatalf("got task queries %v, want one scoped to the user", queries)
+	}
+	for _, arg := range []driver.Value{"mine", "theirs", "also-mine", int64(1)} {
+		if !hasArg(queries[0].args, arg) {
+			t.Errorf("%v wasn't part of the query %v", arg, queries[0])
+		}
+	}
+}
+
+func TestGetTasksByIdsEmpty(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{})
+	tasks, err := db.GetTasksByIds(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks == nil || len(tasks) != 0 {
+		t.Errorf("got %#v, want an empty list", tasks)
+	}
+	if statements := conn.sent(); len(statements) > 0 {
+		t.Errorf("got queries %v, want none", statements)
+	}
+}
+
+func TestTaskRecentActions(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	tests := []struct {
+		name  string
+		query string
+		// The LIMIT the actions are loaded with
+		limit string
+	}{
+		{"requested", `{ task(id: "t", recentActions: 3) { actions { id } } }`, "LIMIT 3"},
+		{"default", `{ task(id: "t") { actions { id } } }`, "LIMIT 20"},
+	}
+	for _, test := range tests {
+		// The database applies the limit, so the fake returns the actions it would
+		db, conn := newFakeDatabase(t, systemClock{},
+			fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("t", now)}},
+			fakeResult{`FROM "actions"`, actionColumns, doneRows("t", now.Add(2*time.Hour), now.Add(time.Hour), now)},
+		)
+		data := runQuery(t, db, test.query, nil)
+		actions := data["task"].(map[string]interface{})["actions"].([]interface{})
+		if len(actions) != 3 {
+			t.Errorf("%s: got actions %v, want the 3 loaded", test.name, actions)
+		}
+
+		loaded := false
+		for _, statement := range conn.sent() {
+			if !strings.Contains(statement.query, `FROM "actions"`) {
+				continue
+			}
+			loaded = true
+			if !strings.Contains(statement.query, `ORDER BY "when" DESC`) || !strings.HasSuffix(statement.query, test.limit) {
+				t.Errorf("%s: loaded actions with %s, want the most recent by %s", test.name, statement.query, test.limit)
+			}
+		}
+		if !loaded {
+			t.Errorf("%s: the task's actions weren't loaded: %v", test.name, conn.sent())
+		}
+	}
+}
+
+func TestTaskRecentActionsOutOfRange(t *testing.T) {
+	for _, recentActions := range []int{0, -1, maxRecentActions + 1} {
+		db, conn := newFakeDatabase(t, systemClock{})
+		result := graphql.Do(graphql.Params{
+			Schema:         *GetSchema(db),
+			RequestString:  `query($n: Int) { task(id: "t", recentActions: $n) { id } }`,
+			VariableValues: map[string]interface{}{"n": recentActions},
+			Context:        context.WithValue(context.Background(), UserIdKey, uint64(1)),
+		})
+		if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Message, "recentActions") {
+			t.Errorf("%d: got errors %v, want recentActions rejected", recentActions, result.Errors)
+		}
+		if statements := conn.sent(); len(statements) > 0 {
+			t.Errorf("%d: got queries %v, want none", recentActions, statements)
+		}
+	}
+}
+
+func TestAddTaskReturnsStoredTask(t *testing.T) {
+	created := mustTime(t, "2017-01-10T12:00:00Z")
+	// The fake returns the row the database would have stored, with the ID
+	// and timestamps it set
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`INSERT INTO "tasks"`, []string{"id"}, [][]driver.Value{{"generated"}}},
+		fakeResult{`FROM "tasks"`, []string{"id", "kind", "title", "user_id", "done", "created_at", "updated_at"},
+			[][]driver.Value{{"generated", int64(TaskEnum), "Buy milk", int64(1), false, created, created}}},
+		fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(1)}}},
+		fakeResult{"count(*)", []string{"count"}, [][]driver.Value{{int64(0)}}},
+	)
+	data := runQuery(t, db, `mutation { addTask(title: "Buy milk") { id title done created_at updated_at } }`, nil)
+	task := data["addTask"].(map[string]interface{})
+	if task["id"] != "generated" || task["title"] != "Buy milk" || task["done"] != false {
+		t.Errorf("got %v, want the stored task", task)
+	}
+	for _, field := range []string{"created_at", "updated_at"} {
+		if task[field] != created.Format(time.RFC3339) {
+			t.Errorf("got %s %v, want %s", field, task[field], created.Format(time.RFC3339))
+		}
+	}
+
+	// The task is reloaded after it's created rather than echoed back
+	inserted := false
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, `INSERT INTO "tasks"`) {
+			inserted = true
+		}
+		if inserted && strings.HasPrefix(statement.query, `SELECT * FROM "tasks"`) && hasArg(statement.args, "generated") {
+			return
+		}
+	}
+	t.Errorf("the created task wasn't reloaded: %v", conn.sent())
+}
+
+func TestAddTaskRetry(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	// The task was created by an earlier attempt
+	db, conn := newFakeDatabase(t, systemClock{},
+		fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("chosen", now)}})
+	task, err := db.AddTask(&Task{Id: "chosen", Kind: TaskEnum, Title: "chosen"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Id != "chosen" {
+		t.Errorf("got %+v, want the task already created", task)
+	}
+	for _, statement := range conn.sent() {
+		if strings.HasPrefix(statement.query, "INSERT") {
+			t.Errorf("the task was created again by %s", statement.query)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"time"
+)
+
+// TimelineAction is an action along with the title of its task.
+type TimelineAction struct {
+	Id        string     `json:"id"`
+	Kind      ActionKind `json:"kind"`
+	When      *time.Time `json:"when"`
+	TaskId    string     `json:"task_id"`
+	TaskTitle string     `json:"task_title"`
+}
+
+// TimelineEntry holds the actions performed on one day.
+type TimelineEntry struct {
+	Date    time.Time        `json:"date"`
+	Actions []TimelineAction `json:"actions"`
+}
+
+// Returns the actions on all of the user's tasks between from (inclusive) and
+// to (exclusive), grouped by UTC day in chronological order. Clients page
+// through the timeline by moving the window.
+func (db gormDB) GetActionTimeline(userId uint64, from time.Time, to time.Time) ([]TimelineEntry, error) {
+	var actions []TimelineAction
+	err := db.Table("actions").
+		Select(`actions.id, actions.kind, actions."when", actions.task_id, tasks.title AS task_title`).
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where(`tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions."when" >= ? AND actions."when" < ?`, userId, from, to).
+		Order(`actions."when"`).
+		Scan(&actions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TimelineEntry
+	for _, action := range actions {
+		when := action.When.UTC()
+		date := time.Date(when.Year(), when.Month(), when.Day(), 0, 0, 0, 0, time.UTC)
+		if len(entries) == 0 || !entries[len(entries)-1].Date.Equal(date) {
+			entries = append(entries, TimelineEntry{Date: date})
+		}
+		last := &entries[len(entries)-1]
+		last.Actions = append(last.Actions, action)
+	}
+	return entries, nil
+}
+
+// Returns the user's tasks and habits with at least one action between from
+// (inclusive) and to (exclusive), optionally only counting actions of kind.
+func (db gormDB) GetTasksWithActivity(userId uint64, from time.Time, to time.Time, kind *ActionKind) ([]Task, error) {
+	activity := `EXISTS (SELECT 1 FROM actions
+		WHERE actions.task_id = tasks.id AND actions.deleted_at IS NULL AND actions."when" >= ? AND actions."when" < ?`
+	args := []interface{}{from, to}
+	if kind != nil {
+		activity += " AND actions.kind = ?"
+		args = append(args, *kind)
+	}
+	activity += ")"
+
+	var tasks []Task
+	err := db.Preload("Actions").
+		Where("user_id = ?", userId).
+		Where(activity, args...).
+		Order("created_at").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// ActionFilter narrows and pages the actions returned by GetAllActions.
+type ActionFilter struct {
+	Kind *ActionKind
+	// Only actions at or after From and before To
+	From *time.Time
+	To   *time.Time
+	// The page of actions to return, most recent first
+	Limit  int
+	Offset int
+}
+
+// Returns the actions on all of the user's tasks and habits with their
+// titles, most recent first.
+func (db gormDB) GetAllActions(userId uint64, filter ActionFilter) ([]TimelineAction, error) {
+	query := db.reader().Table("actions").
+		Select(`actions.id, actions.kind, actions."when", actions.task_id, tasks.title AS task_title`).
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where("tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL", userId)
+	if filter.Kind != nil {
+		query = query.Where("actions.kind = ?", *filter.Kind)
+	}
+	if filter.From != nil {
+		query = query.Where(`actions."when" >= ?`, *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where(`actions."when" < ?`, *filter.To)
+	}
+
+	actions := []TimelineAction{}
+	err := query.
+		Order(`actions."when" DESC, actions.id`).
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Scan(&actions).Error
+	return actions, err
+}
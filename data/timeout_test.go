@@ -0,0 +1,60 @@
+package data
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+func TestDataSourceNameStatementTimeout(t *testing.T) {
+	defer func(saved time.Duration) { statementTimeout = saved }(statementTimeout)
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{1500 * time.Millisecond, " statement_timeout=1500"},
+		{30 * time.Second, " statement_timeout=30000"},
+		{0, ""},
+	}
+	for _, test := range tests {
+		statementTimeout = test.timeout
+		dsn := dataSourceName("localhost", "duet", "duet")
+		got := ""
+		if i := strings.Index(dsn, " statement_timeout="); i >= 0 {
+			got = dsn[i:]
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.timeout, dsn, test.want)
+		}
+	}
+}
+
+// Needs a Postgres server, which is set with DUET_TEST_POSTGRES_HOST and
+// must have a duet user and database.
+func TestStatementTimeoutAbortsSlowQuery(t *testing.T) {
+	host := os.Getenv("DUET_TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("DUET_TEST_POSTGRES_HOST isn't set")
+	}
+	defer func(saved time.Duration) { statementTimeout = saved }(statementTimeout)
+	statementTimeout = 100 * time.Millisecond
+
+	db, err := gorm.Open("postgres", dataSourceName(host, "duet", "duet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	err = db.Exec("SELECT pg_sleep(5)").Error
+	if pqErr, ok := err.(*pq.Error); !ok || pqErr.Code != "57014" {
+		t.Errorf("got %v, want the query cancelled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the query ran for %s", elapsed)
+	}
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"time"
+)
+
+// TodayView holds what a user needs to see for the current day.
+type TodayView struct {
+	DueToday       []Task `json:"due_today"`
+	Overdue        []Task `json:"overdue"`
+	Habits         []Task `json:"habits"`
+	CompletedToday int64  `json:"completed_today"`
+}
+
+// Returns the user's tasks due today, tasks overdue from before today, habits
+// still to be met in their current period and how many completions were
+// recorded today. Days and habit periods start at midnight in loc.
+func (db gormDB) GetTodayView(userId uint64, loc *time.Location, now time.Time) (TodayView, error) {
+	view := TodayView{
+		DueToday: []Task{},
+		Overdue:  []Task{},
+		Habits:   []Task{},
+	}
+	dayStart := periodStartIn(Daily, now, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	// Everything but the completion count is bucketed from the open tasks
+	var tasks []Task
+	err := db.Preload("Actions").
+		Where("user_id = ? AND done = ?", userId, false).
+		Where("kind = ? OR end_date < ?", HabitEnum, dayEnd).
+		Order("created_at").
+		Find(&tasks).Error
+	if err != nil {
+		return view, err
+	}
+	for _, task := range tasks {
+		switch {
+		case task.Kind == HabitEnum:
+			if habitPendingIn(&task, now, loc) {
+				view.Habits = append(view.Habits, task)
+			}
+		case task.EndDate.Before(dayStart):
+			view.Overdue = append(view.Overdue, task)
+		default:
+			view.DueToday = append(view.DueToday, task)
+		}
+	}
+
+	err = db.Table("actions").
+		Joins("JOIN tasks ON tasks.id = actions.task_id").
+		Where(`tasks.user_id = ? AND tasks.deleted_at IS NULL AND actions.deleted_at IS NULL AND actions.kind = ? AND actions."when" >= ? AND actions."when" < ?`,
+			userId, ActionDone, dayStart, dayEnd).
+		Count(&view.CompletedToday).Error
+	if err != nil {
+		return view, err
+	}
+	return view, nil
+}
+
+// Returns whether a habit with its actions loaded has been completed fewer
+// than frequency times in the period containing now.
+func habitPendingIn(habit *Task, now time.Time, loc *time.Location) bool {
+	start := periodStartIn(habit.Interval, now, loc)
+	completions := 0
+	for _, t := range habitDoneTimes(habit) {
+		if !t.Before(start) {
+			completions++
+		}
+	}
+	return completions < habit.Frequency
+}
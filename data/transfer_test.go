@@ -0,0 +1,60 @@
+package data
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestTransferTask(t *testing.T) {
+	task := fakeResult{`FROM "tasks"`, taskColumns, [][]driver.Value{taskRow("t", mustTime(t, "2017-01-10T12:00:00Z"))}}
+	recipient := fakeResult{`FROM "users"`, []string{"id"}, [][]driver.Value{{int64(2)}}}
+	tests := []struct {
+		name    string
+		results []fakeResult
+		// Whether the task is transferred
+		ok bool
+	}{
+		{"transferred", []fakeResult{task, recipient}, true},
+		{"not owned", []fakeResult{recipient}, false},
+		{"no recipient", []fakeResult{task}, false},
+	}
+	for _, test := range tests {
+		db, conn := newFakeDatabase(t, systemClock{}, test.results...)
+		transferred, err := db.TransferTask("t", 1, 2)
+
+		moved := false
+		for _, statement := range conn.sent() {
+			if strings.HasPrefix(statement.query, `UPDATE "tasks" SET "user_id"`) && hasArg(statement.args, int64(2)) && hasArg(statement.args, "t") {
+				moved = true
+			}
+		}
+		if moved != test.ok {
+			t.Errorf("%s: got task moved %t, want %t", test.name, moved, test.ok)
+		}
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, transferred)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if transferred.Id != "t" || transferred.UserId != 2 {
+			t.Errorf("%s: got %+v, want task t of user 2", test.name, transferred)
+		}
+	}
+}
+
+func TestTransferTaskChecksSource(t *testing.T) {
+	db, conn := newFakeDatabase(t, systemClock{})
+	if _, err := db.TransferTask("t", 1, 2); err != ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	statements := conn.sent()
+	if len(statements) == 0 || !strings.Contains(statements[0].query, `FROM "tasks"`) || !hasArg(statements[0].args, int64(1)) {
+		t.Errorf("the task wasn't looked up among the sender's: %v", statements)
+	}
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BucketStat is how many times a habit was completed in one of its periods.
+type BucketStat struct {
+	Start       time.Time `json:"start"`
+	Completions int       `json:"completions"`
+}
+
+// The most periods a trend can cover, a year of a daily habit.
+const maxTrendBuckets = 366
+
+// The date_trunc unit for each interval. Postgres weeks start on Monday like
+// periodStart's.
+var trendUnits = map[Interval]string{
+	Daily:   "day",
+	Weekly:  "week",
+	Monthly: "month",
+}
+
+// Returns how many times a habit was completed in each of its last buckets
+// periods up to and including the current one, oldest first. Periods are in
+// UTC and completions from before a restart are counted.
+func (db gormDB) GetHabitTrend(taskId string, userId uint64, buckets int) ([]BucketStat, error) {
+	if buckets < 1 || buckets > maxTrendBuckets {
+		return nil, &ValidationError{"buckets", fmt.Sprintf("must be between 1 and %d", maxTrendBuckets)}
+	}
+	db = db.reader()
+
+	var habit Task
+	err := db.Select("interval").
+		Where("id = ? AND user_id = ? AND kind = ?", taskId, userId, HabitEnum).
+		First(&habit).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current := periodStart(habit.Interval, db.Now())
+	first := current
+	for i := 1; i < buckets; i++ {
+		first = previousPeriod(habit.Interval, first)
+	}
+	var rows []struct {
+		Bucket time.Time
+		Count  int
+	}
+	err = db.Table("actions").
+		Select(`date_trunc(?, "when" AT TIME ZONE 'UTC') AS bucket, count(*) AS count`, trendUnits[habit.Interval]).
+		Where(`task_id = ? AND kind = ? AND "when" >= ? AND "when" < ? AND deleted_at IS NULL`,
+			taskId, ActionDone, first, nextPeriod(habit.Interval, current)).
+		Group("bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int64]int)
+	for _, row := range rows {
+		// The truncated times have no zone, so they're read back as UTC
+		counts[row.Bucket.Unix()] = row.Count
+	}
+	trend := make([]BucketStat, 0, buckets)
+	for start := first; !start.After(current); start = nextPeriod(habit.Interval, start) {
+		trend = append(trend, BucketStat{
+			Start:       start,
+			Completions: counts[start.Unix()],
+		})
+	}
+	return trend, nil
+}
+
+// Returns how many times a habit was completed in each hour of the day in
+// loc, so clients can suggest when to remind the user.
+func (db gormDB) GetHabitHourHistogram(taskId string, userId uint64, loc *time.Location) ([24]int, error) {
+	var histogram [24]int
+	db = db.reader()
+
+	var habit Task
+	err := db.Select("id").
+		Where("id = ? AND user_id = ? AND kind = ?", taskId, userId, HabitEnum).
+		First(&habit).Error
+	if err == gorm.ErrRecordNotFound {
+		return histogram, ErrNotFound
+	}
+	if err != nil {
+		return histogram, err
+	}
+
+	var rows []struct {
+		Hour  int
+		Count int
+	}
+	err = db.Table("actions").
+		Select(`extract(hour from "when" AT TIME ZONE ?)::int AS hour, count(*) AS count`, loc.String()).
+		Where("task_id = ? AND kind = ? AND deleted_at IS NULL", taskId, ActionDone).
+		Group("hour").
+		Scan(&rows).Error
+	if err != nil {
+		return histogram, err
+	}
+	for _, row := range rows {
+		histogram[row.Hour] = row.Count
+	}
+	return histogram, nil
+}
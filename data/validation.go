@@ -0,0 +1,352 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andyzg/duet/config"
+)
+
+// ValidationError is returned when user input is rejected before reaching the database.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid %s: %s", e.Field, e.Message)
+}
+
+// Trims a title and collapses runs of whitespace inside it to single spaces.
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// The longest usernames and task titles allowed, in characters. They can't
+// be configured past the size of their columns, see checkLengthLimits.
+var maxUsernameLength = config.Int("DUET_MAX_USERNAME_LENGTH", 32)
+var maxTitleLength = config.Int("DUET_MAX_TITLE_LENGTH", 200)
+
+// Returns an error if a configured length limit doesn't fit in its column.
+func checkLengthLimits() error {
+	limits := []struct {
+		name   string
+		length int
+		model  interface{}
+		field  string
+	}{
+		{"DUET_MAX_USERNAME_LENGTH", maxUsernameLength, User{}, "Username"},
+		{"DUET_MAX_TITLE_LENGTH", maxTitleLength, Task{}, "Title"},
+	}
+	for _, limit := range limits {
+		size, err := columnSize(limit.model, limit.field)
+		if err != nil {
+			return err
+		}
+		if limit.length < 1 || limit.length > size {
+			return fmt.Errorf("Invalid length for %s: %d must be between 1 and %d, the size of its column",
+				limit.name, limit.length, size)
+		}
+	}
+	return nil
+}
+
+// Returns the size set in a model field's gorm tag, so that limits can't
+// drift from the columns they protect.
+func columnSize(model interface{}, field string) (int, error) {
+	structField, ok := reflect.TypeOf(model).FieldByName(field)
+	if !ok {
+		return 0, fmt.Errorf("%T has no field %s", model, field)
+	}
+	for _, setting := range strings.Split(structField.Tag.Get("gorm"), ";") {
+		if strings.HasPrefix(setting, "size:") {
+			size, err := strconv.Atoi(strings.TrimPrefix(setting, "size:"))
+			if err != nil {
+				return 0, fmt.Errorf("%T.%s has an invalid column size: %v", model, field, err)
+			}
+			return size, nil
+		}
+	}
+	return 0, fmt.Errorf("%T.%s has no column size", model, field)
+}
+
+func validateLength(field string, value string, max int) error {
+	if utf8.RuneCountInString(value) > max {
+		return &ValidationError{field, fmt.Sprintf("must be at most %d characters", max)}
+	}
+	return nil
+}
+
+func validateTitle(title string) error {
+	if title == "" {
+		return &ValidationError{"title", "can't be empty"}
+	}
+	return validateLength("title", title, maxTitleLength)
+}
+
+func validateUsername(username string) error {
+	if username == "" {
+		return &ValidationError{"username", "can't be empty"}
+	}
+	return validateLength("username", username, maxUsernameLength)
+}
+
+// The enums are stored as integers, so values from outside of GraphQL need
+// to be checked before they reach the database.
+
+func validateTaskKind(kind TaskKind) error {
+	if kind != TaskEnum && kind != HabitEnum {
+		return &ValidationError{"kind", fmt.Sprintf("%d is not a task kind", kind)}
+	}
+	return nil
+}
+
+func validateInterval(interval Interval) error {
+	if interval < Daily || interval > Monthly {
+		return &ValidationError{"interval", fmt.Sprintf("%d is not an interval", interval)}
+	}
+	return nil
+}
+
+func validateActionKind(kind ActionKind) error {
+	if kind < ActionProgress || kind > ActionCustom {
+		return &ValidationError{"kind", fmt.Sprintf("%d is not an action kind", kind)}
+	}
+	return nil
+}
+
+// Validates a task before it's created.
+func validateTask(task *Task) error {
+	if err := validateTitle(task.Title); err != nil {
+		return err
+	}
+	if err := validateTaskKind(task.Kind); err != nil {
+		return err
+	}
+	if task.Kind == HabitEnum {
+		if err := validateInterval(task.Interval); err != nil {
+			return err
+		}
+		if err := validateFrequency(task.Interval, task.Frequency); err != nil {
+			return err
+		}
+	}
+	if err := validateRecurrenceRule(task.RecurrenceRule); err != nil {
+		return err
+	}
+	if err := validateColor(task.Color); err != nil {
+		return err
+	}
+	if err := validateReminderLead(task.ReminderLead); err != nil {
+		return err
+	}
+	return validateIcon(task.Icon)
+}
+
+// The task columns clients may change with UpdateTask. Ownership, IDs and
+// timestamps are managed by the server.
+var updatableTaskFields = map[string]bool{
+	"title":           true,
+	"start_date":      true,
+	"end_date":        true,
+	"recurrence_rule": true,
+	"done":            true,
+	"pinned":          true,
+	"color":           true,
+	"icon":            true,
+	"interval":        true,
+	"frequency":       true,
+	"reminder_lead":   true,
+}
+
+// Whether updates with fields that can't be changed are rejected rather than
+// having those fields dropped.
+var strictTaskUpdates = config.Bool("DUET_STRICT_TASK_UPDATES", true)
+
+// Removes the fields that can't be updated from attrs, or rejects the update
+// if strict updates are enabled.
+func filterTaskAttrs(attrs map[string]interface{}) error {
+	for field := range attrs {
+		if updatableTaskFields[field] {
+			continue
+		}
+		if strictTaskUpdates {
+			return &ValidationError{field, "can't be updated"}
+		}
+		delete(attrs, field)
+	}
+	return nil
+}
+
+// Validates the attributes of a task update that can be checked on their own.
+func validateTaskAttrs(attrs map[string]interface{}) error {
+	if title, ok := attrs["title"].(string); ok {
+		if err := validateTitle(title); err != nil {
+			return err
+		}
+	}
+	if interval, ok := attrs["interval"].(Interval); ok {
+		if err := validateInterval(interval); err != nil {
+			return err
+		}
+	}
+	if rule, ok := attrs["recurrence_rule"].(string); ok {
+		if err := validateRecurrenceRule(rule); err != nil {
+			return err
+		}
+	}
+	if color, ok := attrs["color"].(string); ok {
+		if err := validateColor(color); err != nil {
+			return err
+		}
+	}
+	if icon, ok := attrs["icon"].(string); ok {
+		if err := validateIcon(icon); err != nil {
+			return err
+		}
+	}
+	if lead, ok := attrs["reminder_lead"].(time.Duration); ok {
+		if err := validateReminderLead(lead); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reminders can be at most a month before the end of a period.
+const maxReminderLead = 31 * 24 * time.Hour
+
+func validateReminderLead(lead time.Duration) error {
+	if lead < 0 || lead > maxReminderLead {
+		return &ValidationError{"reminder_lead", "must be between 0 and 31 days"}
+	}
+	return nil
+}
+
+var colorRegexp = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// Colors are optional but must be hex codes like #1a2b3c.
+func validateColor(color string) error {
+	if color != "" && !colorRegexp.MatchString(color) {
+		return &ValidationError{"color", "must be a hex color like #1a2b3c"}
+	}
+	return nil
+}
+
+// The icons clients know how to display.
+var taskIcons = map[string]bool{
+	"book":     true,
+	"check":    true,
+	"exercise": true,
+	"food":     true,
+	"heart":    true,
+	"home":     true,
+	"money":    true,
+	"music":    true,
+	"sleep":    true,
+	"star":     true,
+	"water":    true,
+	"work":     true,
+}
+
+func validateIcon(icon string) error {
+	if icon != "" && !taskIcons[icon] {
+		return &ValidationError{"icon", fmt.Sprintf("\"%s\" is not a known icon", icon)}
+	}
+	return nil
+}
+
+// ActionLimitError is returned when adding an action to a task that already
+// has the most actions allowed.
+type ActionLimitError struct {
+	TaskId string
+	Limit  int
+}
+
+func (e *ActionLimitError) Error() string {
+	return fmt.Sprintf("Task %s already has the maximum of %d actions", e.TaskId, e.Limit)
+}
+
+// The most actions a task may have, or 0 for no limit.
+var maxActionsPerTask = config.Int("DUET_MAX_ACTIONS_PER_TASK", 0)
+
+// TaskLimitError is returned when creating tasks would take a user over the
+// most tasks allowed.
+type TaskLimitError struct {
+	Limit int
+}
+
+func (e *TaskLimitError) Error() string {
+	return fmt.Sprintf("Users can have at most %d tasks and habits", e.Limit)
+}
+
+// The most tasks and habits a user may have, or 0 for no limit.
+var maxTasksPerUser = config.Int("DUET_MAX_TASKS_PER_USER", 0)
+
+// The most completions a habit may require per interval.
+var maxHabitFrequency = map[Interval]int{
+	Daily:   config.Int("DUET_MAX_DAILY_FREQUENCY", 24),
+	Weekly:  config.Int("DUET_MAX_WEEKLY_FREQUENCY", 7*24),
+	Monthly: config.Int("DUET_MAX_MONTHLY_FREQUENCY", 31*24),
+}
+
+// The interval and frequency given to habits created without them.
+var defaultHabitInterval, defaultHabitFrequency = habitDefaults()
+
+func habitDefaults() (Interval, int) {
+	interval := Daily
+	name := config.String("DUET_DEFAULT_HABIT_INTERVAL", "daily")
+	found := false
+	for i, intervalName := range intervalNames {
+		if strings.EqualFold(name, intervalName) {
+			interval = i
+			found = true
+		}
+	}
+	if !found {
+		log.Printf("Invalid interval for DUET_DEFAULT_HABIT_INTERVAL: \"%s\", using daily", name)
+	}
+
+	frequency := config.Int("DUET_DEFAULT_HABIT_FREQUENCY", 1)
+	if err := validateFrequency(interval, frequency); err != nil {
+		log.Printf("Invalid frequency for DUET_DEFAULT_HABIT_FREQUENCY: %d %s, using 1", frequency, err.(*ValidationError).Message)
+		frequency = 1
+	}
+	return interval, frequency
+}
+
+func validateFrequency(interval Interval, frequency int) error {
+	if frequency < 1 {
+		return &ValidationError{"frequency", "must be at least 1"}
+	}
+	if max, ok := maxHabitFrequency[interval]; ok && frequency > max {
+		return &ValidationError{"frequency", fmt.Sprintf("must be at most %d for this interval", max)}
+	}
+	return nil
+}
+
+// How far in the future an action may be dated to allow for client clocks
+// running ahead of the server.
+var maxClockSkew = config.Duration("DUET_MAX_CLOCK_SKEW", 5*time.Minute)
+
+func validateAction(action *Action, task *Task, now time.Time) error {
+	if err := validateActionKind(action.Kind); err != nil {
+		return err
+	}
+	if action.When == nil {
+		return &ValidationError{"when", "is required"}
+	}
+	// Completing a one-off task in the future would show it as done before it
+	// is. Recurring tasks move on to their next occurrence instead.
+	if action.Kind == ActionDone && task.Kind == TaskEnum && task.RecurrenceRule == "" &&
+		action.When.After(now.Add(maxClockSkew)) {
+		return &ValidationError{"when", "a task can't be completed in the future"}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateLengths(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		value    string
+		valid    bool
+	}{
+		{"empty title", validateTitle, "", false},
+		{"longest title", validateTitle, strings.Repeat("a", maxTitleLength), true},
+		{"title too long", validateTitle, strings.Repeat("a", maxTitleLength+1), false},
+		// Lengths are in characters rather than bytes
+		{"multibyte title", validateTitle, strings.Repeat("é", maxTitleLength), true},
+		{"empty username", validateUsername, "", false},
+		{"longest username", validateUsername, strings.Repeat("a", maxUsernameLength), true},
+		{"username too long", validateUsername, strings.Repeat("a", maxUsernameLength+1), false},
+	}
+	for _, test := range tests {
+		err := test.validate(test.value)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if _, ok := err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%s: got %v, want a ValidationError", test.name, err)
+		}
+	}
+}
+
+func TestColumnSize(t *testing.T) {
+	tests := []struct {
+		model interface{}
+		field string
+		size  int
+		// Whether the field has no valid size
+		invalid bool
+	}{
+		{User{}, "Username", 255, false},
+		{Task{}, "Title", 255, false},
+		{Task{}, "Color", 0, true},
+		{Task{}, "Missing", 0, true},
+	}
+	for _, test := range tests {
+		size, err := columnSize(test.model, test.field)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%T.%s: got a size of %d, want an error", test.model, test.field, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%T.%s: %s", test.model, test.field, err)
+		} else if size != test.size {
+			t.Errorf("%T.%s: got a size of %d, want %d", test.model, test.field, size, test.size)
+		}
+	}
+}
+
+func TestCheckLengthLimits(t *testing.T) {
+	tests := []struct {
+		username int
+		title    int
+		valid    bool
+	}{
+		{32, 200, true},
+		{255, 255, true},
+		{256, 200, false},
+		{32, 256, false},
+		{0, 200, false},
+	}
+	defer func(username, title int) {
+		maxUsernameLength, maxTitleLength = username, title
+	}(maxUsernameLength, maxTitleLength)
+	for _, test := range tests {
+		maxUsernameLength, maxTitleLength = test.username, test.title
+		if err := checkLengthLimits(); (err == nil) != test.valid {
+			t.Errorf("usernames of %d and titles of %d: got %v, want valid %t", test.username, test.title, err, test.valid)
+		}
+	}
+}
+
+func TestValidateFrequency(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"daily frequency", validateFrequency(Daily, 24), true},
+		{"daily frequency too high", validateFrequency(Daily, 25), false},
+		{"weekly frequency", validateFrequency(Weekly, 168), true},
+		{"weekly frequency too high", validateFrequency(Weekly, 169), false},
+		{"monthly frequency", validateFrequency(Monthly, 744), true},
+		{"monthly frequency too high", validateFrequency(Monthly, 745), false},
+		{"frequency of 0", validateFrequency(Daily, 0), false},
+	}
+	for _, test := range tests {
+		if test.valid && test.err != nil {
+			t.Errorf("%s: %s", test.name, test.err)
+		}
+		if _, ok := test.err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%s: got %v, want a ValidationError", test.name, test.err)
+		}
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	now := mustTime(t, "2017-01-10T12:00:00Z")
+	past := now.Add(-time.Hour)
+	skewed := now.Add(maxClockSkew)
+	future := now.Add(maxClockSkew + time.Second)
+	task := &Task{Kind: TaskEnum}
+	recurring := &Task{Kind: TaskEnum, RecurrenceRule: "FREQ=DAILY"}
+	habit := &Task{Kind: HabitEnum}
+
+	tests := []struct {
+		name   string
+		action *Action
+		task   *Task
+		valid  bool
+	}{
+		{"done", &Action{Kind: ActionDone, When: &past}, task, true},
+		{"done within the clock skew", &Action{Kind: ActionDone, When: &skewed}, task, true},
+		{"done in the future", &Action{Kind: ActionDone, When: &future}, task, false},
+		{"recurring task done in the future", &Action{Kind: ActionDone, When: &future}, recurring, true},
+		{"habit done in the future", &Action{Kind: ActionDone, When: &future}, habit, true},
+		{"deferred to the future", &Action{Kind: ActionDefer, When: &future}, task, true},
+		{"no time", &Action{Kind: ActionDone}, task, false},
+		{"unknown kind", &Action{Kind: ActionKind(9), When: &past}, task, false},
+	}
+	for _, test := range tests {
+		err := validateAction(test.action, test.task, now)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if _, ok := err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%s: got %v, want a ValidationError", test.name, err)
+		}
+	}
+}
+
+func TestValidateColorAndIcon(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"no color", validateColor(""), true},
+		{"color", validateColor("#1a2B3c"), true},
+		{"short color", validateColor("#abc"), false},
+		{"named color", validateColor("red"), false},
+		{"color without #", validateColor("1a2b3c"), false},
+		{"no icon", validateIcon(""), true},
+		{"icon", validateIcon("water"), true},
+		{"unknown icon", validateIcon("rocket"), false},
+	}
+	for _, test := range tests {
+		if test.valid && test.err != nil {
+			t.Errorf("%s: %s", test.name, test.err)
+		}
+		if _, ok := test.err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%s: got %v, want a ValidationError", test.name, test.err)
+		}
+	}
+}
+
+func TestFilterTaskAttrs(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		attrs  map[string]interface{}
+		// The fields left after filtering, if the update is allowed
+		fields []string
+		valid  bool
+	}{
+		{"updatable fields", true, map[string]interface{}{"title": "a", "done": true}, []string{"done", "title"}, true},
+		{"rejected field", true, map[string]interface{}{"title": "a", "user_id": 2}, nil, false},
+		{"dropped field", false, map[string]interface{}{"title": "a", "user_id": 2}, []string{"title"}, true},
+	}
+	defer func(strict bool) { strictTaskUpdates = strict }(strictTaskUpdates)
+	for _, test := range tests {
+		strictTaskUpdates = test.strict
+		err := filterTaskAttrs(test.attrs)
+		if !test.valid {
+			if _, ok := err.(*ValidationError); !ok {
+				t.Errorf("%s: got %v, want a ValidationError", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(test.attrs) != len(test.fields) {
+			t.Errorf("%s: got %v, want the fields %v", test.name, test.attrs, test.fields)
+			continue
+		}
+		for _, field := range test.fields {
+			if _, ok := test.attrs[field]; !ok {
+				t.Errorf("%s: got %v, want the fields %v", test.name, test.attrs, test.fields)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateReminderLead(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"no reminder", validateReminderLead(0), true},
+		{"longest reminder", validateReminderLead(31 * 24 * time.Hour), true},
+		{"reminder too early", validateReminderLead(31*24*time.Hour + time.Second), false},
+		{"negative reminder", validateReminderLead(-time.Second), false},
+	}
+	for _, test := range tests {
+		if test.valid && test.err != nil {
+			t.Errorf("%s: %s", test.name, test.err)
+		}
+		if _, ok := test.err.(*ValidationError); !test.valid && !ok {
+			t.Errorf("%s: got %v, want a ValidationError", test.name, test.err)
+		}
+	}
+}
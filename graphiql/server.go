@@ -1,8 +1,13 @@
 package graphiql
 
-import "net/http"
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
 
-// Content ...
+// Content is the GraphiQL page. __GRAPHQL_PATH__ is replaced with the path of
+// the GraphQL endpoint when served.
 var Content = []byte(`
 <!DOCTYPE html>
 <head>
@@ -21,12 +26,12 @@ var Content = []byte(`
         text: "Please give the GraphQL HTTP Endpoint",
         type: "input",
         showCancelButton: false,
-        inputPlaceholder: window.location.origin + '/graphql',
+        inputPlaceholder: window.location.origin + '__GRAPHQL_PATH__',
       };
       document.addEventListener('DOMContentLoaded', function () {
         swal(PROMPT_OPTIONS, function(endpoint){
           if (!endpoint) {
-            endpoint = window.location.origin + '/graphql';
+            endpoint = window.location.origin + '__GRAPHQL_PATH__';
           }
           function fetcher(params) {
             var options = {
@@ -52,7 +57,15 @@ var Content = []byte(`
 </body>
 `)
 
-// ServeGraphiQL is a handler function for HTTP servers
-func ServeGraphiQL(res http.ResponseWriter, req *http.Request) {
-	res.Write(Content)
+// ServeGraphiQL is a handler function for HTTP servers, using the GraphQL
+// endpoint at /graphql
+var ServeGraphiQL = Handler("/graphql")
+
+// Handler returns a handler function serving GraphiQL for the GraphQL endpoint
+// at graphqlPath
+func Handler(graphqlPath string) http.HandlerFunc {
+	page := bytes.Replace(Content, []byte("__GRAPHQL_PATH__"), []byte(template.JSEscapeString(graphqlPath)), -1)
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Write(page)
+	}
 }
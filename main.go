@@ -1,70 +1,277 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/andyzg/duet/config"
 	"github.com/andyzg/duet/data"
 	"github.com/andyzg/duet/graphiql"
+	"github.com/andyzg/duet/middleware"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/gabrielwong/graphql-go-handler"
 
 	"golang.org/x/net/context"
 )
 
+// Responses smaller than this many bytes aren't worth compressing.
+const gzipMinSize = 1024
+
 func main() {
 	db := data.InitDatabase("postgres", "localhost", "duet", "duet")
 	defer db.Close()
 
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if config.Bool("DUET_DAILY_SNAPSHOTS", true) {
+		go data.RunDailySnapshots(jobs, db)
+	}
+
 	graphqlHandler := handler.New(&handler.Config{
 		Schema: data.GetSchema(db),
 		Pretty: true,
 		Log:    false,
 	})
 
-	authGraphqlHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := data.GetBearerToken(r)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
-		defer cancel()
+	authTimeout := config.Duration("DUET_AUTH_TIMEOUT", 500*time.Millisecond)
+	queryTimeout := config.Duration("DUET_QUERY_TIMEOUT", 2*time.Second)
 
-		userId, err := data.AuthUserId(token)
-		if err != nil {
-			log.Printf("Error verifying token: %s", err.Error())
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-		ctx = context.WithValue(ctx, data.UserIdKey, userId)
+	// Mutations are limited per user to keep one account from overloading
+	// the database. Queries aren't limited.
+	var mutationLimiter *middleware.RateLimiter
+	if limit := config.Int("DUET_MUTATION_RATE_LIMIT", 60); limit > 0 {
+		mutationLimiter = middleware.NewRateLimiter(limit, time.Minute)
+	}
 
-		graphqlHandler.ContextHandler(ctx, w, r)
+	// Requests without credentials can only log in or sign up
+	publicGraphqlHandler := handler.New(&handler.Config{
+		Schema: data.GetPublicSchema(db),
+		Pretty: true,
+		Log:    false,
 	})
 
+	auth := func(r *http.Request) (uint64, error) {
+		return data.AuthRequest(db, r)
+	}
+	authGraphqlHandler := newGraphqlHandler(auth, graphqlHandler, publicGraphqlHandler, mutationLimiter, authTimeout, queryTimeout)
+
+	persistedQueries := loadPersistedQueries()
+	logSampleRate := config.Int("DUET_LOG_SAMPLE_RATE", 1)
+
 	restApi := rest.NewApi()
 	restApi.Use(rest.DefaultDevStack...)
+	// Every REST route takes JSON, so reject other bodies with a 415 rather
+	// than a confusing decode error
+	restApi.Use(&rest.ContentTypeCheckerMiddleware{})
 
 	restRouter, err := rest.MakeRouter(
 		rest.Post("/login", data.ServeLogin(db)),
 		rest.Post("/signup", data.ServeCreateUser(db)),
 		rest.Get("/verify", data.ServeVerifyToken(db)),
+		rest.Get("/session", data.ServeSession(db)),
+		rest.Post("/reissue", data.ServeReissueToken(db)),
+		rest.Post("/deactivate", data.ServeDeactivate(db)),
+		rest.Post("/users/:id/reactivate", data.ServeReactivate(db)),
+		rest.Post("/users/:id/merge", data.ServeMergeUsers(db)),
+		rest.Get("/tasks", data.ServeGetTasks(db)),
+		rest.Get("/actions/:id", data.ServeGetAction(db)),
 	)
 	if err != nil {
 		log.Fatalf("rest.MakeRouter failed, %v", err)
 	}
 	restApi.SetApp(restRouter)
 
-	http.HandleFunc("/", graphiql.ServeGraphiQL)
-	http.Handle("/rest/", http.StripPrefix("/rest", restApi.MakeHandler()))
-	http.Handle("/graphql", authGraphqlHandler)
+	graphqlPath := config.String("DUET_GRAPHQL_PATH", "/graphql")
+
+	handleGraphql(http.DefaultServeMux, graphqlPath,
+		middleware.Gzip(persistedQueries.Handler(middleware.LogOperations(authGraphqlHandler, logSampleRate)), gzipMinSize))
+	http.Handle("/rest/", middleware.Gzip(http.StripPrefix("/rest", restApi.MakeHandler()), gzipMinSize))
+	http.Handle("/report", middleware.Gzip(data.HandleReport(db), gzipMinSize))
+	http.Handle("/import", middleware.Gzip(data.HandleImportExternal(db), gzipMinSize))
 	http.Handle("/oauth/todoist/login", data.HandleTodoistLogin(db))
 	http.Handle("/oauth/todoist/callback", data.HandleTodoistCallback(db))
 
-	err = http.ListenAndServe(":8080", nil)
+	// Each route gets its own CORS policy so that, for example, the web app
+	// can use GraphQL without also being allowed to call the REST endpoints
+	corsPolicies := map[string]middleware.CorsPolicy{}
+	addCorsPolicy(corsPolicies, graphqlPath, "DUET_CORS_GRAPHQL_ORIGINS", []string{"GET", "POST"})
+	addCorsPolicy(corsPolicies, "/rest/", "DUET_CORS_REST_ORIGINS", []string{"GET", "POST"})
+	addCorsPolicy(corsPolicies, "/report", "DUET_CORS_REPORT_ORIGINS", []string{"GET"})
+	addCorsPolicy(corsPolicies, "/import", "DUET_CORS_IMPORT_ORIGINS", []string{"POST"})
+
+	var server http.Handler = middleware.Cors(http.DefaultServeMux, corsPolicies)
+	if maxRequests := config.Int("DUET_MAX_CONCURRENT_REQUESTS", 100); maxRequests > 0 {
+		server = middleware.LimitConcurrency(server, maxRequests, config.Duration("DUET_REQUEST_QUEUE_TIMEOUT", time.Second))
+	}
+
+	err = listenAndServe(":8080", server)
 	if err != nil {
 		log.Fatalf("ListenAndServe failed, %v", err)
 	}
 }
+
+// Serves the GraphQL endpoint at graphqlPath and GraphiQL, pointed at it, from
+// the root.
+func handleGraphql(mux *http.ServeMux, graphqlPath string, graphqlHandler http.Handler) {
+	mux.HandleFunc("/", graphiql.Handler(graphqlPath))
+	mux.Handle(graphqlPath, graphqlHandler)
+}
+
+// Runs GraphQL requests with a context, as the GraphQL handler does.
+type contextHandler interface {
+	ContextHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
+}
+
+// Serves GraphQL requests. Requests without credentials run against the
+// public schema. The rest are authenticated with auth, which is given up on
+// after authTimeout, and then run with queryTimeout to resolve.
+func newGraphqlHandler(auth func(*http.Request) (uint64, error), authed contextHandler, public contextHandler,
+	mutationLimiter *middleware.RateLimiter, authTimeout time.Duration, queryTimeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+			defer cancel()
+			public.ContextHandler(ctx, w, r)
+			return
+		}
+
+		userId, err := authWithTimeout(auth, r, authTimeout)
+		if err == errAuthTimeout {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		data.SlideSession(w.Header(), r)
+		middleware.SetOperationUser(r, userId)
+		if mutationLimiter != nil {
+			// Queries aren't limited but still report the mutation limit
+			key := strconv.FormatUint(userId, 10)
+			if middleware.OperationType(r) != "mutation" {
+				mutationLimiter.Report(w, key)
+			} else if mutationLimiter.Deny(w, key) {
+				return
+			}
+		}
+
+		// The query deadline starts after authentication so a slow
+		// authentication doesn't eat into the resolvers' time
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+		ctx = context.WithValue(ctx, data.UserIdKey, userId)
+
+		authed.ContextHandler(ctx, w, r)
+	})
+}
+
+var errAuthTimeout = errors.New("Authentication timed out")
+
+// Authenticates a request, giving up after timeout. API key lookups hit the
+// database and can stall, so they're bounded separately from the query.
+func authWithTimeout(auth func(*http.Request) (uint64, error), r *http.Request, timeout time.Duration) (uint64, error) {
+	type authResult struct {
+		userId uint64
+		err    error
+	}
+	// Buffered so the lookup can finish and exit after a timeout
+	result := make(chan authResult, 1)
+	go func() {
+		userId, err := auth(r)
+		result <- authResult{userId, err}
+	}()
+
+	select {
+	case res := <-result:
+		return res.userId, res.err
+	case <-time.After(timeout):
+		return 0, errAuthTimeout
+	}
+}
+
+// Adds a CORS policy for the route allowing the origins listed in the
+// environment variable. Routes without any allowed origins are left out.
+func addCorsPolicy(policies map[string]middleware.CorsPolicy, route string, originsVar string, methods []string) {
+	origins := config.List(originsVar, nil)
+	if len(origins) == 0 {
+		return
+	}
+	policies[route] = middleware.CorsPolicy{
+		Origins: origins,
+		Methods: methods,
+		Headers: []string{"Authorization", "Content-Type"},
+		MaxAge:  config.Duration("DUET_CORS_MAX_AGE", 10*time.Minute),
+	}
+}
+
+// Serves over TLS, which also enables HTTP/2, when DUET_TLS_CERT and
+// DUET_TLS_KEY are set and plain HTTP otherwise.
+func listenAndServe(addr string, handler http.Handler) error {
+	// Load the certificate before binding so a bad one fails startup
+	tlsConfig, err := loadTLSConfig(config.String("DUET_TLS_CERT", ""), config.String("DUET_TLS_KEY", ""))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig == nil {
+		return server.ListenAndServe()
+	}
+	log.Printf("Serving TLS on %s", addr)
+	return server.ListenAndServeTLS("", "")
+}
+
+// Returns the TLS config serving the certificate in certFile, or nil if
+// neither file is given.
+func loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("DUET_TLS_CERT and DUET_TLS_KEY must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Loading TLS certificate failed, %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// Creates the persisted query cache. If DUET_APQ_ALLOWLIST names a JSON file
+// containing an array of queries, only those queries are allowed to run.
+func loadPersistedQueries() *middleware.PersistedQueries {
+	cacheSize := config.Int("DUET_APQ_CACHE_SIZE", 1000)
+	allowlistPath := config.String("DUET_APQ_ALLOWLIST", "")
+	if allowlistPath == "" {
+		return middleware.NewPersistedQueries(cacheSize, false)
+	}
+
+	contents, err := ioutil.ReadFile(allowlistPath)
+	if err != nil {
+		log.Fatalf("Reading persisted query allowlist failed, %v", err)
+	}
+	var queries []string
+	if err := json.Unmarshal(contents, &queries); err != nil {
+		log.Fatalf("Parsing persisted query allowlist failed, %v", err)
+	}
+
+	persistedQueries := middleware.NewPersistedQueries(cacheSize, true)
+	for _, query := range queries {
+		persistedQueries.Allow(query)
+	}
+	log.Printf("Loaded %d persisted queries from %s", len(queries), allowlistPath)
+	return persistedQueries
+}
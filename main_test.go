@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyzg/duet/data"
+	"golang.org/x/net/context"
+)
+
+func TestHandleGraphqlAtConfiguredPath(t *testing.T) {
+	mux := http.NewServeMux()
+	handleGraphql(mux, "/api/graphql", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("graphql"))
+	}))
+
+	tests := []struct {
+		path    string
+		graphql bool
+	}{
+		{"/api/graphql", true},
+		{"/graphql", false},
+		{"/", false},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if served := w.Body.String() == "graphql"; served != test.graphql {
+			t.Errorf("%s: got GraphQL served %t, want %t", test.path, served, test.graphql)
+		}
+	}
+
+	// GraphiQL sends queries to the configured path
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, "'/api/graphql'") || strings.Contains(body, "__GRAPHQL_PATH__") {
+		t.Errorf("GraphiQL doesn't point at /api/graphql: %s", body)
+	}
+}
+
+// Writes a self-signed certificate for 127.0.0.1 and its key to dir and
+// returns their paths along with the certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Duet"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duet-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, _ := writeSelfSignedCert(t, dir)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		tls      bool
+		err      bool
+	}{
+		{"neither", "", "", false, false},
+		{"both", certFile, keyFile, true, false},
+		{"only the certificate", certFile, "", false, true},
+		{"only the key", "", keyFile, false, true},
+		{"missing files", filepath.Join(dir, "missing.pem"), keyFile, false, true},
+		{"key as certificate", keyFile, keyFile, false, true},
+	}
+	for _, test := range tests {
+		config, err := loadTLSConfig(test.certFile, test.keyFile)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %t", test.name, err, test.err)
+		}
+		if (config != nil) != test.tls {
+			t.Errorf("%s: got TLS config %v, want TLS %t", test.name, config, test.tls)
+		}
+	}
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "duet-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, cert := writeSelfSignedCert(t, dir)
+	os.Setenv("DUET_TLS_CERT", certFile)
+	os.Setenv("DUET_TLS_KEY", keyFile)
+	defer os.Unsetenv("DUET_TLS_CERT")
+	defer os.Unsetenv("DUET_TLS_KEY")
+
+	// Find a free port for the server to bind
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// The server can't be stopped, so it serves until the tests finish
+	go listenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("https://" + addr + "/"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("the server didn't serve over TLS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.TLS == nil || string(body) != "ok" {
+		t.Errorf("got %q over TLS %v, want ok over TLS", body, resp.TLS)
+	}
+}
+
+// Records the context GraphQL requests are run with.
+type recordingHandler struct {
+	called   bool
+	calledAt time.Time
+	ctx      context.Context
+}
+
+func (h *recordingHandler) ContextHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h.called = true
+	h.calledAt = time.Now()
+	h.ctx = ctx
+}
+
+// Returns an auth function that takes delay to authenticate as user 7 or to
+// fail with err.
+func slowAuth(delay time.Duration, err error) func(*http.Request) (uint64, error) {
+	return func(r *http.Request) (uint64, error) {
+		time.Sleep(delay)
+		if err != nil {
+			return 0, err
+		}
+		return 7, nil
+	}
+}
+
+func TestQueryDeadlineStartsAfterAuth(t *testing.T) {
+	const queryTimeout = 300 * time.Millisecond
+	authed, public := &recordingHandler{}, &recordingHandler{}
+	h := newGraphqlHandler(slowAuth(100*time.Millisecond, nil), authed, public, nil, time.Second, queryTimeout)
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set("Authorization", "ApiKey key")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !authed.called || public.called {
+		t.Fatalf("got authed handler called %t and public %t, want only authed", authed.called, public.called)
+	}
+	deadline, ok := authed.ctx.Deadline()
+	if !ok {
+		t.Fatal("the query has no deadline")
+	}
+	// Allow a little for the time between setting the deadline and running
+	// the query, but far less than the time taken to authenticate
+	if budget := deadline.Sub(authed.calledAt); budget < queryTimeout-50*time.Millisecond {
+		t.Errorf("the query got %s to run, want the full %s", budget, queryTimeout)
+	}
+	if userId := authed.ctx.Value(data.UserIdKey); userId != uint64(7) {
+		t.Errorf("got user %v, want 7", userId)
+	}
+}
+
+func TestAuthDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   func(*http.Request) (uint64, error)
+		status int
+	}{
+		{"slow", slowAuth(200*time.Millisecond, nil), http.StatusServiceUnavailable},
+		{"failed", slowAuth(0, errors.New("Invalid token")), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		authed := &recordingHandler{}
+		h := newGraphqlHandler(test.auth, authed, &recordingHandler{}, nil, 50*time.Millisecond, time.Second)
+		r := httptest.NewRequest("POST", "/graphql", nil)
+		r.Header.Set("Authorization", "ApiKey key")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+		if authed.called {
+			t.Errorf("%s: the query ran", test.name)
+		}
+	}
+}
+
+func TestPublicQueryDeadline(t *testing.T) {
+	const queryTimeout = 300 * time.Millisecond
+	authed, public := &recordingHandler{}, &recordingHandler{}
+	h := newGraphqlHandler(slowAuth(0, nil), authed, public, nil, time.Second, queryTimeout)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/graphql", nil))
+
+	if !public.called || authed.called {
+		t.Fatalf("got public handler called %t and authed %t, want only public", public.called, authed.called)
+	}
+	if deadline, ok := public.ctx.Deadline(); !ok || deadline.Sub(public.calledAt) > queryTimeout {
+		t.Errorf("got deadline %v, want one within %s", deadline, queryTimeout)
+	}
+	if userId := public.ctx.Value(data.UserIdKey); userId != nil {
+		t.Errorf("got user %v, want none", userId)
+	}
+}
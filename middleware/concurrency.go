@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// LimitConcurrency serves at most max requests at once. Requests beyond that
+// wait up to queueTimeout for a slot and then get a 503, so a spike can't
+// open unbounded goroutines and database connections. A queueTimeout of 0
+// rejects excess requests immediately.
+func LimitConcurrency(h http.Handler, max int, queueTimeout time.Duration) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitForSlot(slots, queueTimeout) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer func() { <-slots }()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Waits up to timeout to take a slot, returning whether one was taken.
+func waitForSlot(slots chan struct{}, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueTimeout time.Duration
+		// How long after the request is queued the busy slot is freed
+		releaseAfter time.Duration
+		wantStatus   int
+	}{
+		{"rejected immediately", 0, time.Second, http.StatusServiceUnavailable},
+		{"slot freed in time", time.Second, 10 * time.Millisecond, http.StatusOK},
+		{"queue timed out", 10 * time.Millisecond, time.Second, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		h := LimitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/busy" {
+				close(started)
+				<-release
+			}
+		}), 1, test.queueTimeout)
+
+		busy := make(chan struct{})
+		go func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/busy", nil))
+			close(busy)
+		}()
+		<-started
+
+		timer := time.AfterFunc(test.releaseAfter, func() { close(release) })
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if timer.Stop() {
+			close(release)
+		}
+		<-busy
+
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.wantStatus)
+		}
+		if test.wantStatus == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: got Retry-After %q, want 1", test.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsPolicy describes which cross-origin requests a route accepts.
+type CorsPolicy struct {
+	// Origins allowed to make requests, or "*" for any origin
+	Origins []string
+	Methods []string
+	Headers []string
+	// How long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+func (p CorsPolicy) allows(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// The response headers cross-origin clients may read besides the basic ones.
+var exposedHeaders = []string{
+	"Retry-After",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"X-Refreshed-Token",
+}
+
+// Cors applies a CORS policy to each route. Routes are matched by the longest
+// path prefix in policies, and requests to routes without a policy get no
+// CORS headers, so browsers only allow them from the same origin. Preflight
+// requests are answered here and never reach h.
+func Cors(h http.Handler, policies map[string]CorsPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		policy, ok := corsPolicyFor(policies, r.URL.Path)
+		if origin == "" || !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := policy.allows(origin)
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.Methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.Headers, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns the policy with the longest prefix of path.
+func corsPolicyFor(policies map[string]CorsPolicy, path string) (CorsPolicy, bool) {
+	var policy CorsPolicy
+	longest := -1
+	for prefix, p := range policies {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			policy = p
+			longest = len(prefix)
+		}
+	}
+	return policy, longest >= 0
+}
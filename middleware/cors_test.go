@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCors(t *testing.T) {
+	policies := map[string]CorsPolicy{
+		"/graphql": {
+			Origins: []string{"https://duet.example"},
+			Methods: []string{"GET", "POST"},
+			Headers: []string{"Authorization", "Content-Type"},
+			MaxAge:  10 * time.Minute,
+		},
+		"/rest/":       {Origins: []string{"*"}, Methods: []string{"GET"}},
+		"/rest/admin/": {Origins: []string{"https://admin.example"}, Methods: []string{"POST"}},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantHandled bool
+	}{
+		{"same origin", "GET", "/graphql", "", false, http.StatusOK, "", true},
+		{"allowed origin", "POST", "/graphql", "https://duet.example", false, http.StatusOK, "https://duet.example", true},
+		{"other origin", "POST", "/graphql", "https://evil.example", false, http.StatusOK, "", true},
+		{"route without a policy", "GET", "/report", "https://duet.example", false, http.StatusOK, "", true},
+		{"any origin", "GET", "/rest/tasks", "https://evil.example", false, http.StatusOK, "https://evil.example", true},
+		{"longest prefix", "POST", "/rest/admin/users", "https://evil.example", false, http.StatusOK, "", true},
+		{"allowed preflight", "OPTIONS", "/graphql", "https://duet.example", true, http.StatusNoContent, "https://duet.example", false},
+		{"rejected preflight", "OPTIONS", "/graphql", "https://evil.example", true, http.StatusForbidden, "", false},
+	}
+	for _, test := range tests {
+		handled := false
+		h := Cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled = true
+		}), policies)
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.wantStatus)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != test.wantOrigin {
+			t.Errorf("%s: got allowed origin %q, want %q", test.name, origin, test.wantOrigin)
+		}
+		if handled != test.wantHandled {
+			t.Errorf("%s: got handled %t, want %t", test.name, handled, test.wantHandled)
+		}
+		if test.wantOrigin == "" || test.preflight {
+			continue
+		}
+		if exposed := w.Header().Get("Access-Control-Expose-Headers"); exposed == "" {
+			t.Errorf("%s: no headers were exposed", test.name)
+		}
+	}
+}
+
+func TestCorsPreflightHeaders(t *testing.T) {
+	h := Cors(http.NotFoundHandler(), map[string]CorsPolicy{
+		"/graphql": {
+			Origins: []string{"https://duet.example"},
+			Methods: []string{"GET", "POST"},
+			Headers: []string{"Authorization", "Content-Type"},
+			MaxAge:  10 * time.Minute,
+		},
+	})
+	r := httptest.NewRequest("OPTIONS", "/graphql", nil)
+	r.Header.Set("Origin", "https://duet.example")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	want := map[string]string{
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Authorization, Content-Type",
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("got %s %q, want %q", header, got, value)
+		}
+	}
+}
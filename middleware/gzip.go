@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Gzip compresses responses for clients that send "Accept-Encoding: gzip".
+// Responses smaller than minSize bytes are written uncompressed since the gzip
+// overhead outweighs the savings.
+func Gzip(h http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			minSize:        minSize,
+			status:         http.StatusOK,
+		}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if encoding == "gzip" || encoding == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the response until it reaches minSize, at which
+// point it commits to compressing the rest of the body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	buf         []byte
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < w.minSize {
+		return len(b), nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// start writes the header and the buffered body, compressing if the handler
+// hasn't already encoded the response itself.
+func (w *gzipResponseWriter) start() error {
+	buf := w.buf
+	w.buf = nil
+
+	if w.Header().Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(buf)
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(buf)
+	return err
+}
+
+// Flush commits to the current encoding decision and flushes to the client.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz == nil && !w.passthrough {
+		w.start()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes out any response that never reached minSize and terminates the
+// gzip stream.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.passthrough {
+		return nil
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
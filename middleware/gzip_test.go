@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	const minSize = 100
+	large := bytes.Repeat([]byte("duet "), 100)
+	small := []byte("duet")
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           []byte
+		status         int
+		// Set by the handler, which compresses the body itself
+		contentEncoding string
+		wantGzip        bool
+	}{
+		{"not accepted", "", large, http.StatusOK, "", false},
+		{"below the minimum size", "gzip", small, http.StatusOK, "", false},
+		{"accepted", "gzip", large, http.StatusOK, "", true},
+		{"accepted with weights", "deflate, gzip;q=0.5", large, http.StatusOK, "", true},
+		{"any encoding", "*", large, http.StatusOK, "", true},
+		{"other encodings only", "deflate, br", large, http.StatusOK, "", false},
+		{"already encoded", "gzip", large, http.StatusOK, "br", false},
+		{"other status", "gzip", large, http.StatusCreated, "", true},
+	}
+	for _, test := range tests {
+		h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.contentEncoding != "" {
+				w.Header().Set("Content-Encoding", test.contentEncoding)
+			}
+			w.WriteHeader(test.status)
+			w.Write(test.body)
+		}), minSize)
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: got Vary %q, want Accept-Encoding", test.name, vary)
+		}
+		body := w.Body.Bytes()
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.wantGzip {
+			t.Errorf("%s: got gzipped %t, want %t", test.name, gzipped, test.wantGzip)
+			continue
+		}
+		if gzipped {
+			reader, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			if body, err = ioutil.ReadAll(reader); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		if !bytes.Equal(body, test.body) {
+			t.Errorf("%s: got a body of %d bytes, want %d", test.name, len(body), len(test.body))
+		}
+	}
+}
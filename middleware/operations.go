@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type operationKey struct{}
+
+// The GraphQL operation of a request. The user is filled in by the wrapped
+// handler once the request is authenticated.
+type operation struct {
+	kind   string
+	name   string
+	userId uint64
+	authed bool
+}
+
+// SetOperationUser records the authenticated user of a request for
+// LogOperations. It does nothing if the request isn't being logged.
+func SetOperationUser(r *http.Request, userId uint64) {
+	if op, ok := r.Context().Value(operationKey{}).(*operation); ok {
+		op.userId = userId
+		op.authed = true
+	}
+}
+
+// OperationType returns whether the request is a query, mutation or
+// subscription, or an empty string if it didn't pass through LogOperations.
+func OperationType(r *http.Request) string {
+	if op, ok := r.Context().Value(operationKey{}).(*operation); ok {
+		return op.kind
+	}
+	return ""
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Records whether a response failed, either with an error status or with
+// GraphQL errors in its body.
+type errorRecorder struct {
+	http.ResponseWriter
+	failed bool
+}
+
+var graphQLErrorsKey = []byte(`"errors"`)
+
+func (e *errorRecorder) WriteHeader(status int) {
+	if status >= 400 {
+		e.failed = true
+	}
+	e.ResponseWriter.WriteHeader(status)
+}
+
+func (e *errorRecorder) Write(b []byte) (int, error) {
+	if bytes.Contains(b, graphQLErrorsKey) {
+		e.failed = true
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+// LogOperations logs the name and type of the GraphQL operation in each
+// request along with the user who made it. Variable values are left out of
+// the log since they can hold user content. Only one in sampleRate successful
+// requests is logged, while failed requests are always logged.
+func LogOperations(h http.Handler, sampleRate int) http.Handler {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+	var requests uint64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if r.Method == "POST" {
+			raw, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			// Bodies that aren't JSON are left for the GraphQL handler to reject
+			json.Unmarshal(raw, &req)
+		} else {
+			values := r.URL.Query()
+			req.Query = values.Get("query")
+			req.OperationName = values.Get("operationName")
+			json.Unmarshal([]byte(values.Get("variables")), &req.Variables)
+		}
+
+		op := &operation{}
+		op.kind, op.name = describeOperation(req.Query, req.OperationName)
+		r = r.WithContext(context.WithValue(r.Context(), operationKey{}, op))
+		recorder := &errorRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, r)
+
+		sampled := atomic.AddUint64(&requests, 1)%uint64(sampleRate) == 0
+		if !sampled && !recorder.failed {
+			return
+		}
+
+		userId := "-"
+		if op.authed {
+			userId = strconv.FormatUint(op.userId, 10)
+		}
+		status := "ok"
+		if recorder.failed {
+			status = "error"
+		}
+		log.Printf("GraphQL %s %s user=%s variables=%s status=%s",
+			op.kind, op.name, userId, redactVariables(req.Variables), status)
+	})
+}
+
+// Returns the type and name of the operation a request runs, which is the
+// named operation or the only one in the query.
+func describeOperation(query string, operationName string) (string, string) {
+	name := operationName
+	if name == "" {
+		name = "anonymous"
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return "invalid", name
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			if operationName == "" && op.Name != nil {
+				name = op.Name.Value
+			}
+			return op.Operation, name
+		}
+	}
+	return "unknown", name
+}
+
+// Lists the variable names with their values replaced.
+func redactVariables(variables map[string]interface{}) string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name+"=[redacted]")
+	}
+	sort.Strings(names)
+	return "{" + strings.Join(names, " ") + "}"
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Serves a request through LogOperations and returns what was logged.
+func logOperation(h http.Handler, sampleRate int, r *http.Request) string {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	LogOperations(h, sampleRate).ServeHTTP(httptest.NewRecorder(), r)
+	return logged.String()
+}
+
+// A handler that authenticates every request as user 7.
+var authedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	SetOperationUser(r, 7)
+})
+
+func TestLogOperationsNamedQuery(t *testing.T) {
+	body := `{"query":"query GetTask($id: String!) { task(id: $id) { title } }","variables":{"id":"secret-task-id"}}`
+	logged := logOperation(authedHandler, 1, httptest.NewRequest("POST", "/graphql", strings.NewReader(body)))
+	want := "GraphQL query GetTask user=7 variables={id=[redacted]} status=ok"
+	if !strings.Contains(logged, want) {
+		t.Errorf("got log %q, want it to contain %q", logged, want)
+	}
+	if strings.Contains(logged, "secret-task-id") {
+		t.Errorf("variable value was logged: %q", logged)
+	}
+}
+
+func TestLogOperationsGet(t *testing.T) {
+	values := url.Values{}
+	values.Set("query", "query A { tasks { id } } mutation B { addTask(title: \"x\") { id } }")
+	values.Set("operationName", "B")
+	logged := logOperation(authedHandler, 1, httptest.NewRequest("GET", "/graphql?"+values.Encode(), nil))
+	want := "GraphQL mutation B user=7 variables={} status=ok"
+	if !strings.Contains(logged, want) {
+		t.Errorf("got log %q, want it to contain %q", logged, want)
+	}
+}
+
+func TestLogOperationsUnauthenticated(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	logged := logOperation(h, 1, httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ tasks { id } }"}`)))
+	want := "GraphQL query anonymous user=- variables={} status=ok"
+	if !strings.Contains(logged, want) {
+		t.Errorf("got log %q, want it to contain %q", logged, want)
+	}
+}
+
+func TestLogOperationsSampling(t *testing.T) {
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":null,"errors":[{"message":"failed"}]}`))
+	})
+	tests := []struct {
+		name   string
+		h      http.Handler
+		logged bool
+	}{
+		{"successful", authedHandler, false},
+		{"failed", failing, true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"query Named { tasks { id } }"}`))
+		logged := logOperation(test.h, 2, r) != ""
+		if logged != test.logged {
+			t.Errorf("%s: got logged %t, want %t", test.name, logged, test.logged)
+		}
+	}
+}
+
+func TestDescribeOperation(t *testing.T) {
+	tests := []struct {
+		query         string
+		operationName string
+		kind          string
+		name          string
+	}{
+		{"{ tasks { id } }", "", "query", "anonymous"},
+		{"query Tasks { tasks { id } }", "", "query", "Tasks"},
+		{"mutation Add { addTask(title: \"x\") { id } }", "", "mutation", "Add"},
+		{"query A { tasks { id } } mutation B { deleteTask(id: \"x\") }", "B", "mutation", "B"},
+		{"query A { tasks { id } }", "C", "unknown", "C"},
+		{"{", "", "invalid", "anonymous"},
+	}
+	for _, test := range tests {
+		kind, name := describeOperation(test.query, test.operationName)
+		if kind != test.kind || name != test.name {
+			t.Errorf("%q as %q: got %s %s, want %s %s", test.query, test.operationName, kind, name, test.kind, test.name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// PersistedQueries implements automatic persisted queries: clients send the
+// sha256 hash of a query instead of its text, and the full query only needs to
+// be sent the first time. In allowlist mode only registered queries may run.
+type PersistedQueries struct {
+	mu        sync.Mutex
+	capacity  int
+	queries   map[string]*list.Element
+	lru       *list.List
+	allowlist map[string]string
+}
+
+type persistedQuery struct {
+	hash  string
+	query string
+}
+
+// NewPersistedQueries creates a cache holding up to capacity queries. If
+// allowlist is true, unknown queries are rejected instead of cached.
+func NewPersistedQueries(capacity int, allowlist bool) *PersistedQueries {
+	pq := &PersistedQueries{
+		capacity: capacity,
+		queries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	if allowlist {
+		pq.allowlist = make(map[string]string)
+	}
+	return pq
+}
+
+// Allow adds a query to the allowlist and returns its hash. Outside of
+// allowlist mode the query is added to the cache instead.
+func (pq *PersistedQueries) Allow(query string) string {
+	hash := hashQuery(query)
+	if pq.allowlist == nil {
+		pq.put(hash, query)
+		return hash
+	}
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	pq.allowlist[hash] = query
+	return hash
+}
+
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func (pq *PersistedQueries) get(hash string) (string, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if pq.allowlist != nil {
+		query, ok := pq.allowlist[hash]
+		return query, ok
+	}
+	if elem, ok := pq.queries[hash]; ok {
+		pq.lru.MoveToFront(elem)
+		return elem.Value.(*persistedQuery).query, true
+	}
+	return "", false
+}
+
+func (pq *PersistedQueries) put(hash string, query string) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if elem, ok := pq.queries[hash]; ok {
+		pq.lru.MoveToFront(elem)
+		return
+	}
+	pq.queries[hash] = pq.lru.PushFront(&persistedQuery{hash, query})
+	for pq.lru.Len() > pq.capacity {
+		oldest := pq.lru.Back()
+		pq.lru.Remove(oldest)
+		delete(pq.queries, oldest.Value.(*persistedQuery).hash)
+	}
+}
+
+type persistedQueryExtension struct {
+	PersistedQuery *struct {
+		Version    int    `json:"version"`
+		Sha256Hash string `json:"sha256Hash"`
+	} `json:"persistedQuery"`
+}
+
+// Handler resolves persisted query hashes in POSTed JSON bodies and GET
+// parameters into full queries before passing the request on to h.
+func (pq *PersistedQueries) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		var query, extensions string
+
+		if r.Method == "POST" {
+			raw, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			if json.Unmarshal(raw, &body) != nil {
+				// Not a JSON body, leave it for the GraphQL handler to parse
+				body = nil
+			}
+			query, _ = body["query"].(string)
+			if ext, ok := body["extensions"]; ok {
+				encoded, _ := json.Marshal(ext)
+				extensions = string(encoded)
+			}
+		} else {
+			query = r.URL.Query().Get("query")
+			extensions = r.URL.Query().Get("extensions")
+		}
+
+		hash := ""
+		if extensions != "" {
+			var ext persistedQueryExtension
+			if err := json.Unmarshal([]byte(extensions), &ext); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, "Invalid extensions: "+err.Error())
+				return
+			}
+			if ext.PersistedQuery != nil {
+				hash = ext.PersistedQuery.Sha256Hash
+			}
+		}
+
+		if hash == "" {
+			if query != "" && !pq.allowed(hashQuery(query)) {
+				writeGraphQLError(w, http.StatusForbidden, "PersistedQueryNotAllowed")
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if query == "" {
+			cached, ok := pq.get(hash)
+			if !ok {
+				if pq.allowlist != nil {
+					writeGraphQLError(w, http.StatusForbidden, "PersistedQueryNotAllowed")
+				} else {
+					writeGraphQLError(w, http.StatusOK, "PersistedQueryNotFound")
+				}
+				return
+			}
+			query = cached
+		} else {
+			if hashQuery(query) != hash {
+				writeGraphQLError(w, http.StatusBadRequest, "provided sha does not match query")
+				return
+			}
+			if pq.allowlist != nil {
+				if !pq.allowed(hash) {
+					writeGraphQLError(w, http.StatusForbidden, "PersistedQueryNotAllowed")
+					return
+				}
+			} else {
+				pq.put(hash, query)
+			}
+		}
+
+		if body != nil {
+			body["query"] = query
+			raw, _ := json.Marshal(body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			r.ContentLength = int64(len(raw))
+		} else {
+			values := r.URL.Query()
+			values.Set("query", query)
+			r.URL.RawQuery = values.Encode()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Whether a query may run. Everything is allowed outside of allowlist mode.
+func (pq *PersistedQueries) allowed(hash string) bool {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if pq.allowlist == nil {
+		return true
+	}
+	_, ok := pq.allowlist[hash]
+	return ok
+}
+
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{
+			{"message": message},
+		},
+	})
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const persistedQueryText = "{ tasks { id } }"
+
+// Returns the extensions referring to a persisted query by its hash.
+func persistedExtensions(hash string) string {
+	return `{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`
+}
+
+// Serves a request through pq and returns the response and the query the
+// wrapped handler saw, if it was called.
+func servePersisted(pq *PersistedQueries, r *http.Request) (*httptest.ResponseRecorder, string, bool) {
+	var seen string
+	called := false
+	h := pq.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if r.Method == "POST" {
+			var body map[string]interface{}
+			raw, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(raw, &body)
+			seen, _ = body["query"].(string)
+		} else {
+			seen = r.URL.Query().Get("query")
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w, seen, called
+}
+
+func persistedPost(query, extensions string) *http.Request {
+	body := map[string]interface{}{}
+	if query != "" {
+		body["query"] = query
+	}
+	if extensions != "" {
+		body["extensions"] = json.RawMessage(extensions)
+	}
+	raw, _ := json.Marshal(body)
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(string(raw)))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func persistedGet(query, extensions string) *http.Request {
+	values := url.Values{}
+	if query != "" {
+		values.Set("query", query)
+	}
+	if extensions != "" {
+		values.Set("extensions", extensions)
+	}
+	return httptest.NewRequest("GET", "/graphql?"+values.Encode(), nil)
+}
+
+func TestPersistedQueries(t *testing.T) {
+	hash := hashQuery(persistedQueryText)
+	other := hashQuery("{ habits { id } }")
+
+	tests := []struct {
+		name      string
+		allowlist bool
+		// Whether the query is registered before the request
+		registered bool
+		query      string
+		extensions string
+		wantStatus int
+		wantError  string
+		wantQuery  string
+	}{
+		{"plain query", false, false, persistedQueryText, "", http.StatusOK, "", persistedQueryText},
+		{"unknown hash", false, false, "", persistedExtensions(hash), http.StatusOK, "PersistedQueryNotFound", ""},
+		{"known hash", false, true, "", persistedExtensions(hash), http.StatusOK, "", persistedQueryText},
+		{"hash and query", false, false, persistedQueryText, persistedExtensions(hash), http.StatusOK, "", persistedQueryText},
+		{"mismatched hash", false, false, persistedQueryText, persistedExtensions(other), http.StatusBadRequest, "provided sha does not match query", ""},
+		{"invalid extensions", false, false, "", `{"persistedQuery":"x"}`, http.StatusBadRequest, "Invalid extensions", ""},
+		{"allowed hash", true, true, "", persistedExtensions(hash), http.StatusOK, "", persistedQueryText},
+		{"allowed query", true, true, persistedQueryText, "", http.StatusOK, "", persistedQueryText},
+		{"unlisted hash", true, false, "", persistedExtensions(hash), http.StatusForbidden, "PersistedQueryNotAllowed", ""},
+		{"unlisted query", true, false, persistedQueryText, "", http.StatusForbidden, "PersistedQueryNotAllowed", ""},
+		{"unlisted hash and query", true, false, persistedQueryText, persistedExtensions(hash), http.StatusForbidden, "PersistedQueryNotAllowed", ""},
+	}
+	for _, test := range tests {
+		for _, method := range []string{"POST", "GET"} {
+			pq := NewPersistedQueries(10, test.allowlist)
+			if test.registered {
+				pq.Allow(persistedQueryText)
+			}
+			r := persistedGet(test.query, test.extensions)
+			if method == "POST" {
+				r = persistedPost(test.query, test.extensions)
+			}
+			w, seen, called := servePersisted(pq, r)
+
+			if w.Code != test.wantStatus {
+				t.Errorf("%s over %s: got status %d, want %d", test.name, method, w.Code, test.wantStatus)
+			}
+			if test.wantError != "" {
+				if called {
+					t.Errorf("%s over %s: the query was run", test.name, method)
+				}
+				if !strings.Contains(w.Body.String(), test.wantError) {
+					t.Errorf("%s over %s: got %q, want the error %q", test.name, method, w.Body.String(), test.wantError)
+				}
+				continue
+			}
+			if !called || seen != test.wantQuery {
+				t.Errorf("%s over %s: ran %q, want %q", test.name, method, seen, test.wantQuery)
+			}
+		}
+	}
+}
+
+func TestPersistedQueriesCached(t *testing.T) {
+	pq := NewPersistedQueries(10, false)
+	hash := hashQuery(persistedQueryText)
+	servePersisted(pq, persistedPost(persistedQueryText, persistedExtensions(hash)))
+
+	// Later requests only need the hash
+	_, seen, _ := servePersisted(pq, persistedGet("", persistedExtensions(hash)))
+	if seen != persistedQueryText {
+		t.Errorf("got %q, want %q", seen, persistedQueryText)
+	}
+}
+
+func TestPersistedQueriesEviction(t *testing.T) {
+	pq := NewPersistedQueries(2, false)
+	first := pq.Allow("{ a }")
+	second := pq.Allow("{ b }")
+	// Using the first query makes the second the least recently used
+	pq.get(first)
+	third := pq.Allow("{ c }")
+
+	tests := []struct {
+		hash   string
+		cached bool
+	}{
+		{first, true},
+		{second, false},
+		{third, true},
+	}
+	for _, test := range tests {
+		if _, ok := pq.get(test.hash); ok != test.cached {
+			t.Errorf("%s: got cached %t, want %t", test.hash, ok, test.cached)
+		}
+	}
+}
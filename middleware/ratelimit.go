@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each key a number of events per period using token
+// buckets, so short bursts up to the limit are allowed.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     float64
+	period    time.Duration
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter allowing limit events per period for each key.
+func NewRateLimiter(limit int, period time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   float64(limit),
+		period:  period,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// The state of a key's bucket, after an event if one was recorded.
+type limitStatus struct {
+	allowed bool
+	// Events still allowed before the limit is reached
+	remaining int
+	// How long until the next event is allowed, if this one wasn't
+	wait time.Duration
+	// When the bucket will be full again
+	reset time.Time
+}
+
+// Returns the state of key's bucket, recording an event first if record is
+// set. Keys are only tracked once they've had an event.
+func (l *RateLimiter) take(key string, record bool) limitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.limit, updated: now}
+		if record {
+			l.buckets[key] = b
+		}
+	}
+	b.tokens += l.refill(now.Sub(b.updated))
+	if b.tokens > l.limit {
+		b.tokens = l.limit
+	}
+	b.updated = now
+
+	status := limitStatus{allowed: b.tokens >= 1}
+	if status.allowed && record {
+		b.tokens--
+	} else if !status.allowed {
+		status.wait = time.Duration((1 - b.tokens) / l.limit * float64(l.period))
+	}
+	status.remaining = int(b.tokens)
+	status.reset = now.Add(time.Duration((l.limit - b.tokens) / l.limit * float64(l.period)))
+	return status
+}
+
+// Returns the number of tokens regained over elapsed.
+func (l *RateLimiter) refill(elapsed time.Duration) float64 {
+	return l.limit * float64(elapsed) / float64(l.period)
+}
+
+// Forgets buckets that have refilled so idle keys don't use memory.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.period {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+l.refill(now.Sub(b.updated)) >= l.limit {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Deny records an event for key and, if it's over the limit, writes a 429
+// with a Retry-After header and returns true. Either way the limit, the
+// events remaining and when the limit fully resets are reported in
+// X-RateLimit-* headers so clients can pace themselves.
+func (l *RateLimiter) Deny(w http.ResponseWriter, key string) bool {
+	status := l.take(key, true)
+	l.writeHeaders(w, status)
+	if status.allowed {
+		return false
+	}
+	seconds := int(status.wait/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// Report writes the X-RateLimit-* headers for key without recording an
+// event, for responses to requests that aren't limited.
+func (l *RateLimiter) Report(w http.ResponseWriter, key string) {
+	l.writeHeaders(w, l.take(key, false))
+}
+
+func (l *RateLimiter) writeHeaders(w http.ResponseWriter, status limitStatus) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(l.limit)))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(status.reset.Unix(), 10))
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name       string
+		elapsed    time.Duration
+		key        string
+		wantDenied bool
+		// Only set when denied
+		wantRetryAfter string
+	}{
+		{"first event", 0, "a", false, ""},
+		{"second event", 0, "a", false, ""},
+		{"over the limit", 0, "a", true, "31"},
+		{"other key", 0, "b", false, ""},
+		{"partly refilled", 15 * time.Second, "a", true, "16"},
+		{"refilled one", 15 * time.Second, "a", false, ""},
+		{"over the limit again", 0, "a", true, "31"},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		w := httptest.NewRecorder()
+		denied := l.Deny(w, test.key)
+
+		if denied != test.wantDenied {
+			t.Errorf("%s: got denied %t, want %t", test.name, denied, test.wantDenied)
+		}
+		if denied && w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: got status %d, want 429", test.name, w.Code)
+		}
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != test.wantRetryAfter {
+			t.Errorf("%s: got Retry-After %q, want %q", test.name, retryAfter, test.wantRetryAfter)
+		}
+	}
+}
+
+func TestRateLimiterForgetsIdleKeys(t *testing.T) {
+	now := time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+	l.Deny(httptest.NewRecorder(), "a")
+	now = now.Add(40 * time.Second)
+	l.Deny(httptest.NewRecorder(), "b")
+	l.Deny(httptest.NewRecorder(), "b")
+
+	// By the next sweep a has refilled but b hasn't
+	now = now.Add(25 * time.Second)
+	l.Deny(httptest.NewRecorder(), "c")
+
+	tests := []struct {
+		key     string
+		tracked bool
+	}{
+		{"a", false},
+		{"b", true},
+		{"c", true},
+	}
+	for _, test := range tests {
+		if _, ok := l.buckets[test.key]; ok != test.tracked {
+			t.Errorf("%s: got tracked %t, want %t", test.key, ok, test.tracked)
+		}
+	}
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	now := time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		// Report rather than Deny
+		report        bool
+		wantRemaining int
+		wantReset     time.Duration
+	}{
+		{"first event", 0, false, 1, 30 * time.Second},
+		{"report", 0, true, 1, 30 * time.Second},
+		{"second event", 0, false, 0, time.Minute},
+		{"denied", 0, false, 0, time.Minute},
+		{"report over the limit", 0, true, 0, time.Minute},
+		{"partly refilled", 15 * time.Second, true, 0, 45 * time.Second},
+		{"refilled fully", 2 * time.Minute, true, 2, 0},
+	}
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		w := httptest.NewRecorder()
+		if test.report {
+			l.Report(w, "a")
+		} else {
+			l.Deny(w, "a")
+		}
+
+		if test.report && (w.Code != http.StatusOK || w.Header().Get("Retry-After") != "") {
+			t.Errorf("%s: the report limited the response", test.name)
+		}
+		want := map[string]string{
+			"X-RateLimit-Limit":     "2",
+			"X-RateLimit-Remaining": strconv.Itoa(test.wantRemaining),
+			"X-RateLimit-Reset":     strconv.FormatInt(now.Add(test.wantReset).Unix(), 10),
+		}
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("%s: got %s %q, want %q", test.name, header, got, value)
+			}
+		}
+	}
+}
+
+func TestRateLimiterReportDoesNotTrack(t *testing.T) {
+	l := NewRateLimiter(2, time.Minute)
+	for i := 0; i < 5; i++ {
+		l.Report(httptest.NewRecorder(), "a")
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("got %d tracked keys, want 0", len(l.buckets))
+	}
+	if l.Deny(httptest.NewRecorder(), "a") {
+		t.Error("reports used up the limit")
+	}
+}